@@ -0,0 +1,52 @@
+package pipeline
+
+import (
+	"hash/fnv"
+	"math"
+	"sync"
+)
+
+// 一定のメモリ使用量で、キーを既に追加したかどうかを判定するBloom filter
+// 追加していないキーを追加済みと判定する（偽陽性）ことはあるが、その逆はない
+type bloomFilter struct {
+	mu     sync.Mutex
+	bits   []uint64
+	m      uint64 // ビット数
+	hashes int
+}
+
+// expectedItems件のキーを追加した時点での偽陽性率がfalsePositiveRateになるように、ビット数とハッシュ関数の数を決める
+func newBloomFilter(expectedItems int, falsePositiveRate float64) *bloomFilter {
+	n := float64(max(expectedItems, 1))
+	m := uint64(math.Ceil(-n * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2)))
+	m = max(m, 64)
+	k := int(math.Round(float64(m) / n * math.Ln2))
+
+	return &bloomFilter{
+		bits:   make([]uint64, (m+63)/64),
+		m:      m,
+		hashes: max(k, 1),
+	}
+}
+
+// キーを追加し、追加前に既に追加済みと判定されたかどうかを返す
+func (f *bloomFilter) testAndAdd(key string) bool {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+	// ダブルハッシュ法で、1つのハッシュ値から複数のハッシュ値を作る
+	h1, h2 := sum&0xffffffff, sum>>32|1
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	found := true
+	for i := range uint64(f.hashes) {
+		bit := (h1 + i*h2) % f.m
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			found = false
+			f.bits[bit/64] |= 1 << (bit % 64)
+		}
+	}
+	return found
+}
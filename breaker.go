@@ -0,0 +1,224 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// サーキットブレーカーがopenのため、処理単位が実行されなかったことを示すエラー
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+type CircuitBreakerState string
+
+const (
+	CircuitBreakerClosed   CircuitBreakerState = "Closed"
+	CircuitBreakerOpen     CircuitBreakerState = "Open"
+	CircuitBreakerHalfOpen CircuitBreakerState = "HalfOpen"
+)
+
+const (
+	defaultCircuitBreakerWindow       = 20
+	defaultCircuitBreakerOpenDuration = 10 * time.Second
+)
+
+type CircuitBreakerConfig struct {
+	// 連続でこの回数だけ失敗したらopenにする。0の場合は判定しない
+	ConsecutiveFailures int
+	// 直近Window件の失敗率がこの値以上になったらopenにする。0の場合は判定しない
+	FailureRatio float64
+	// 失敗率を計算する件数。0の場合は20件
+	Window int
+	// 失敗率を判定するのに必要な最低件数。0の場合はWindowと同じ件数
+	MinSamples int
+	// openになってからhalf-openに移行するまでの時間。0の場合は10秒
+	OpenDuration time.Duration
+	// trueの場合、open中は処理単位を失敗させずに、half-openになるまで待機させる
+	WaitWhenOpen bool
+}
+
+// サーキットブレーカーの状態遷移
+type CircuitBreakerTransition struct {
+	From   CircuitBreakerState
+	To     CircuitBreakerState
+	At     time.Time
+	Reason string
+}
+
+type circuitBreaker struct {
+	config CircuitBreakerConfig
+
+	mu          sync.Mutex
+	state       CircuitBreakerState
+	consecutive int
+	results     []bool // 直近の実行結果（trueが失敗）
+	openedAt    time.Time
+	probing     bool
+	changed     chan struct{} // 状態が変化した際にcloseされる
+	transitions []CircuitBreakerTransition
+}
+
+func newCircuitBreaker(config CircuitBreakerConfig) *circuitBreaker {
+	if config.Window <= 0 {
+		config.Window = defaultCircuitBreakerWindow
+	}
+	if config.MinSamples <= 0 || config.MinSamples > config.Window {
+		config.MinSamples = config.Window
+	}
+	if config.OpenDuration <= 0 {
+		config.OpenDuration = defaultCircuitBreakerOpenDuration
+	}
+
+	return &circuitBreaker{
+		config:  config,
+		state:   CircuitBreakerClosed,
+		changed: make(chan struct{}),
+	}
+}
+
+// 処理単位の実行を開始してよいかを判定する
+// half-open中に実行を許可された処理単位は状態を判定するための試行となり、probeがtrueになる
+// 実行できない場合はErrCircuitOpen、もしくは待機中にctxが終了した場合はctx.Err()を返す
+func (b *circuitBreaker) allow(ctx context.Context) (probe bool, err error) {
+	for {
+		b.mu.Lock()
+
+		if b.state == CircuitBreakerOpen && time.Since(b.openedAt) >= b.config.OpenDuration {
+			b.transition(CircuitBreakerHalfOpen, "open duration elapsed")
+		}
+
+		var wait <-chan time.Time
+		switch b.state {
+		case CircuitBreakerClosed:
+			b.mu.Unlock()
+			return false, nil
+		case CircuitBreakerHalfOpen:
+			if !b.probing {
+				b.probing = true
+				b.mu.Unlock()
+				return true, nil
+			}
+		case CircuitBreakerOpen:
+			wait = time.After(b.config.OpenDuration - time.Since(b.openedAt))
+		}
+
+		changed := b.changed
+		b.mu.Unlock()
+
+		if !b.config.WaitWhenOpen {
+			return false, ErrCircuitOpen
+		}
+
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-changed:
+		case <-wait:
+		}
+	}
+}
+
+// 処理単位の実行結果を記録する
+// 成功・失敗のいずれでもないステータス（キャンセルやスキップなど）は判定に利用しない
+func (b *circuitBreaker) record(probe bool, status OutputStatus) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if probe {
+		b.probing = false
+	}
+
+	failed := status.IsFailure()
+	if !failed && status != OutputStatusSuccess {
+		if probe {
+			// 試行の結果が判定できなかったので、次の処理単位で再度試行する
+			b.notify()
+		}
+		return
+	}
+
+	switch b.state {
+	case CircuitBreakerHalfOpen:
+		// half-open中は試行の結果のみで判定する
+		if !probe {
+			return
+		}
+		if failed {
+			b.open("probe failed")
+		} else {
+			b.reset()
+			b.transition(CircuitBreakerClosed, "probe succeeded")
+		}
+	case CircuitBreakerClosed:
+		if failed {
+			b.consecutive++
+		} else {
+			b.consecutive = 0
+		}
+		b.results = append(b.results, failed)
+		if len(b.results) > b.config.Window {
+			b.results = b.results[len(b.results)-b.config.Window:]
+		}
+
+		if b.config.ConsecutiveFailures > 0 && b.consecutive >= b.config.ConsecutiveFailures {
+			b.open(fmt.Sprintf("%d consecutive failures", b.consecutive))
+			return
+		}
+		if b.config.FailureRatio > 0 && len(b.results) >= b.config.MinSamples {
+			failures := 0
+			for _, f := range b.results {
+				if f {
+					failures++
+				}
+			}
+			ratio := float64(failures) / float64(len(b.results))
+			if ratio >= b.config.FailureRatio {
+				b.open(fmt.Sprintf("failure ratio %.2f in last %d units", ratio, len(b.results)))
+			}
+		}
+	}
+}
+
+func (b *circuitBreaker) open(reason string) {
+	b.reset()
+	b.openedAt = time.Now()
+	b.transition(CircuitBreakerOpen, reason)
+}
+
+func (b *circuitBreaker) reset() {
+	b.consecutive = 0
+	b.results = nil
+}
+
+func (b *circuitBreaker) transition(to CircuitBreakerState, reason string) {
+	b.transitions = append(b.transitions, CircuitBreakerTransition{
+		From:   b.state,
+		To:     to,
+		At:     time.Now(),
+		Reason: reason,
+	})
+	b.state = to
+	b.notify()
+}
+
+// 待機中の処理単位に状態の変化を通知する
+func (b *circuitBreaker) notify() {
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+func (b *circuitBreaker) currentState() CircuitBreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.state
+}
+
+func (b *circuitBreaker) history() []CircuitBreakerTransition {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]CircuitBreakerTransition{}, b.transitions...)
+}
@@ -0,0 +1,135 @@
+package pipeline
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_circuitBreaker(t *testing.T) {
+	type step struct {
+		sleep     time.Duration
+		wantProbe bool
+		wantErr   error
+		record    OutputStatus
+		wantState CircuitBreakerState
+	}
+	tests := []struct {
+		name            string
+		config          CircuitBreakerConfig
+		steps           []step
+		wantTransitions []CircuitBreakerState
+	}{
+		{
+			name:   "consecutive failures",
+			config: CircuitBreakerConfig{ConsecutiveFailures: 2, OpenDuration: 50 * time.Millisecond},
+			steps: []step{
+				{record: OutputStatusError, wantState: CircuitBreakerClosed},
+				{record: OutputStatusSuccess, wantState: CircuitBreakerClosed},
+				{record: OutputStatusError, wantState: CircuitBreakerClosed},
+				{record: OutputStatusCancelled, wantState: CircuitBreakerClosed}, // ASSERT: キャンセルは判定に利用されない
+				{record: OutputStatusTimeout, wantState: CircuitBreakerOpen},
+				{wantErr: ErrCircuitOpen, wantState: CircuitBreakerOpen},
+				// half-openになり、試行が失敗すると再度openになる
+				{sleep: 60 * time.Millisecond, wantProbe: true, record: OutputStatusError, wantState: CircuitBreakerOpen},
+				// 試行が成功するとclosedになる
+				{sleep: 60 * time.Millisecond, wantProbe: true, record: OutputStatusSuccess, wantState: CircuitBreakerClosed},
+			},
+			wantTransitions: []CircuitBreakerState{
+				CircuitBreakerOpen,
+				CircuitBreakerHalfOpen,
+				CircuitBreakerOpen,
+				CircuitBreakerHalfOpen,
+				CircuitBreakerClosed,
+			},
+		},
+		{
+			name:   "failure ratio",
+			config: CircuitBreakerConfig{FailureRatio: 0.5, Window: 4, OpenDuration: time.Hour},
+			steps: []step{
+				{record: OutputStatusError, wantState: CircuitBreakerClosed},
+				{record: OutputStatusSuccess, wantState: CircuitBreakerClosed},
+				{record: OutputStatusSuccess, wantState: CircuitBreakerClosed},
+				{record: OutputStatusError, wantState: CircuitBreakerOpen},
+				{wantErr: ErrCircuitOpen, wantState: CircuitBreakerOpen},
+			},
+			wantTransitions: []CircuitBreakerState{
+				CircuitBreakerOpen,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newCircuitBreaker(tt.config)
+
+			for _, s := range tt.steps {
+				time.Sleep(s.sleep)
+
+				probe, err := b.allow(context.Background())
+				assert.Equal(t, s.wantProbe, probe)
+				assert.ErrorIs(t, err, s.wantErr)
+				if err == nil {
+					b.record(probe, s.record)
+				}
+				assert.Equal(t, s.wantState, b.currentState())
+			}
+
+			transitions := []CircuitBreakerState{}
+			for _, tr := range b.history() {
+				transitions = append(transitions, tr.To)
+			}
+			assert.Equal(t, tt.wantTransitions, transitions)
+		})
+	}
+}
+
+func Test_circuitBreaker_WaitWhenOpen(t *testing.T) {
+	b := newCircuitBreaker(CircuitBreakerConfig{ConsecutiveFailures: 1, OpenDuration: 50 * time.Millisecond, WaitWhenOpen: true})
+	b.record(false, OutputStatusError)
+
+	// open中は失敗させずに、half-openになるまで待機する
+	start := time.Now()
+	probe, err := b.allow(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, probe)
+	assert.GreaterOrEqual(t, time.Since(start), 40*time.Millisecond)
+
+	// 試行中の処理単位がある間は待機し、ctxが終了したらそのエラーを返す
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = b.allow(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestStageCircuitBreaker(t *testing.T) {
+	p := New(
+		MapStage("Generator", &testSliceGenerator{records: []Record{
+			testRecord{"error", "id1"},
+			testRecord{"error", "id2"},
+			testRecord{"error", "id3"},
+			testRecord{"group1", "id4"},
+		}}),
+		MapStage("Map", &testMapper{}, StageMaxParallel(1), StageCircuitBreaker(CircuitBreakerConfig{
+			ConsecutiveFailures: 2,
+			OpenDuration:        time.Hour,
+		})),
+	)
+
+	_, stages, err := p.Execute(context.Background())
+	assert.NoError(t, err)
+
+	// 2件連続で失敗した後は、実行されずにスキップされる
+	assert.ElementsMatch(t, []SummarizedOutput{
+		{Unit: "error/id1", Status: OutputStatusError, Err: errTestMapper},
+		{Unit: "error/id2", Status: OutputStatusError, Err: errTestMapper},
+		{Unit: "error/id3", Status: OutputStatusSkipped, Err: ErrCircuitOpen},
+		{Unit: "group1/id4", Status: OutputStatusSkipped, Err: ErrCircuitOpen},
+	}, stages[1].Outputs)
+
+	assert.Len(t, stages[1].CircuitBreakerTransitions, 1)
+	assert.Equal(t, CircuitBreakerClosed, stages[1].CircuitBreakerTransitions[0].From)
+	assert.Equal(t, CircuitBreakerOpen, stages[1].CircuitBreakerTransitions[0].To)
+	assert.Equal(t, "2 consecutive failures", stages[1].CircuitBreakerTransitions[0].Reason)
+}
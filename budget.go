@@ -0,0 +1,70 @@
+package pipeline
+
+import (
+	"fmt"
+	"sync"
+)
+
+type ErrorBudgetThreshold string
+
+const (
+	ErrorBudgetMaxErrors ErrorBudgetThreshold = "MaxErrors"
+	ErrorBudgetErrorRate ErrorBudgetThreshold = "ErrorRate"
+)
+
+// ステージで失敗した処理単位が許容量を超えたことを表すエラー
+type ErrorBudgetExceededError struct {
+	Threshold ErrorBudgetThreshold // 超過した閾値の種類
+	Failures  int                  // 超過した時点で失敗した処理単位の数
+	Samples   int                  // 超過した時点で成功もしくは失敗した処理単位の数
+}
+
+func (e *ErrorBudgetExceededError) Error() string {
+	return fmt.Sprintf("error budget exceeded (%s): %d failures in %d units", e.Threshold, e.Failures, e.Samples)
+}
+
+type errorBudget struct {
+	maxErrors    int
+	maxErrorRate float64
+	minSamples   int
+
+	mu       sync.Mutex
+	failures int
+	samples  int
+	exceeded bool
+}
+
+// 処理単位の実行結果を記録し、許容量を初めて超えた場合はエラーを返す
+// 成功・失敗のいずれでもないステータス（キャンセルやスキップなど）は判定に利用しない
+func (b *errorBudget) record(status OutputStatus) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if status.IsFailure() {
+		b.failures++
+	} else if status != OutputStatusSuccess {
+		return nil
+	}
+	b.samples++
+
+	if b.exceeded {
+		return nil
+	}
+
+	var threshold ErrorBudgetThreshold
+	switch {
+	case b.maxErrors > 0 && b.failures > b.maxErrors:
+		threshold = ErrorBudgetMaxErrors
+	case b.maxErrorRate > 0 && b.samples >= b.minSamples && float64(b.failures)/float64(b.samples) > b.maxErrorRate:
+		threshold = ErrorBudgetErrorRate
+	default:
+		return nil
+	}
+
+	b.exceeded = true
+	return &ErrorBudgetExceededError{
+		Threshold: threshold,
+		Failures:  b.failures,
+		Samples:   b.samples,
+	}
+}
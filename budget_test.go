@@ -0,0 +1,79 @@
+package pipeline
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStageMaxErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		records []Record
+		option  PipelineStageOption
+		wantErr *AbortError
+	}{
+		{
+			name: "max errors",
+			records: []Record{
+				testRecord{"error", "id1"},
+				testRecord{"group1", "id2"},
+				testRecord{"error", "id3"},
+				testRecord{"group1", "id4"},
+			},
+			option: StageMaxErrors(1),
+			wantErr: &AbortError{
+				Stage: "Map",
+				Unit:  "error/id3",
+				Err: &ErrorBudgetExceededError{
+					Threshold: ErrorBudgetMaxErrors,
+					Failures:  2,
+					Samples:   3,
+				},
+			},
+		},
+		{
+			name: "error rate",
+			records: []Record{
+				testRecord{"group1", "id1"},
+				testRecord{"error", "id2"}, // ASSERT: 1/2は閾値を超えないので継続される
+				testRecord{"error", "id3"},
+				testRecord{"group1", "id4"},
+			},
+			option: StageAbortIfErrorRateExceeds(0.5, 2),
+			wantErr: &AbortError{
+				Stage: "Map",
+				Unit:  "error/id3",
+				Err: &ErrorBudgetExceededError{
+					Threshold: ErrorBudgetErrorRate,
+					Failures:  2,
+					Samples:   3,
+				},
+			},
+		},
+		{
+			name: "within budget",
+			records: []Record{
+				testRecord{"error", "id1"},
+				testRecord{"group1", "id2"},
+				testRecord{"group1", "id3"},
+			},
+			option: StageAbortIfErrorRateExceeds(0.5, 2),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := New(
+				MapStage("Generator", &testSliceGenerator{records: tt.records}),
+				MapStage("Map", &testMapper{}, StageMaxParallel(1), tt.option),
+			).Start(context.Background()).Result()
+
+			if tt.wantErr == nil {
+				assert.NoError(t, res.Err)
+				return
+			}
+			assert.Equal(t, tt.wantErr, res.Err)
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package pipeline
+
+import "sync"
+
+// レコードのサイズ（バイト数）を返す関数
+type RecordSizeFunc func(r Record) int64
+
+// ステージの入力をn件までバッファリングする
+// 前段は後段の処理を待たずにレコードを渡せるようになる
+func StageBuffer(n int) PipelineStageOption {
+	return func(s *PipelineStage) {
+		s.bufferRecords = n
+	}
+}
+
+// ステージの入力を、sizeで計算したレコードのサイズの合計がmaxBytesに達するまでバッファリングする
+// StageBufferと併用した場合は、いずれかの上限に達した時点で前段からの読み込みを待機する
+// バッファが空の場合は、maxBytesを超えるレコードも受け付ける
+func StageBufferBytes(maxBytes int64, size RecordSizeFunc) PipelineStageOption {
+	return func(s *PipelineStage) {
+		s.bufferBytes = maxBytes
+		s.recordSize = size
+	}
+}
+
+// ステージの入力をバッファリングするキュー
+type recordQueue struct {
+	maxRecords int
+	maxBytes   int64
+	size       RecordSizeFunc
+
+	mu       sync.Mutex
+	depth    int
+	bytes    int64
+	maxDepth int
+}
+
+func newRecordQueue(maxRecords int, maxBytes int64, size RecordSizeFunc) *recordQueue {
+	if maxBytes > 0 && size == nil {
+		maxBytes = 0
+	}
+	if maxRecords <= 0 && maxBytes <= 0 {
+		return nil
+	}
+	return &recordQueue{
+		maxRecords: maxRecords,
+		maxBytes:   maxBytes,
+		size:       size,
+	}
+}
+
+// 入力をキューに読み込み、到着順に出力するchannelを返す
+func (q *recordQueue) run(inputs <-chan Record) <-chan Record {
+	outputs := make(chan Record)
+
+	go func() {
+		defer close(outputs)
+
+		items := []Record{}
+		sizes := []int64{}
+		for inputs != nil || len(items) > 0 {
+			// キューが上限に達している間は前段からの読み込みを止める
+			var recv <-chan Record
+			if inputs != nil && q.accepts() {
+				recv = inputs
+			}
+			var send chan<- Record
+			var next Record
+			if len(items) > 0 {
+				send = outputs
+				next = items[0]
+			}
+
+			select {
+			case in, ok := <-recv:
+				if !ok {
+					inputs = nil
+					continue
+				}
+				var size int64
+				if _, ok := in.(groupCommit); !ok && q.size != nil {
+					size = q.size(Unwrap(in))
+				}
+				items = append(items, in)
+				sizes = append(sizes, size)
+				q.update(1, size)
+			case send <- next:
+				q.update(-1, -sizes[0])
+				items[0] = nil
+				items = items[1:]
+				sizes = sizes[1:]
+			}
+		}
+	}()
+
+	return outputs
+}
+
+func (q *recordQueue) accepts() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.depth == 0 {
+		return true
+	}
+	if q.maxRecords > 0 && q.depth >= q.maxRecords {
+		return false
+	}
+	if q.maxBytes > 0 && q.bytes >= q.maxBytes {
+		return false
+	}
+	return true
+}
+
+func (q *recordQueue) update(depth int, bytes int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.depth += depth
+	q.bytes += bytes
+	q.maxDepth = max(q.maxDepth, q.depth)
+}
+
+// キューに溜まっているレコードの数とサイズの合計、これまでの最大のレコードの数を返す
+func (q *recordQueue) stats() (depth int, bytes int64, maxDepth int) {
+	if q == nil {
+		return 0, 0, 0
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.depth, q.bytes, q.maxDepth
+}
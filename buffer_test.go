@@ -0,0 +1,65 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testSlowMapper struct{}
+
+func (m *testSlowMapper) Map(ctx context.Context, input Record) ([]Record, error) {
+	time.Sleep(10 * time.Millisecond)
+	return []Record{input}, nil
+}
+
+func TestStageBuffer(t *testing.T) {
+	records := []Record{}
+	for i := range 10 {
+		records = append(records, testRecord{"group", fmt.Sprint(i)})
+	}
+	size := func(r Record) int64 { return 4 }
+
+	tests := []struct {
+		name          string
+		opts          []PipelineStageOption
+		wantMaxQueued int
+	}{
+		{
+			name:          "no buffer",
+			opts:          []PipelineStageOption{},
+			wantMaxQueued: 0,
+		},
+		{
+			name:          "records",
+			opts:          []PipelineStageOption{StageBuffer(3)},
+			wantMaxQueued: 3,
+		},
+		{
+			name: "bytes",
+			opts: []PipelineStageOption{StageBufferBytes(10, size)},
+			// ASSERT: サイズの合計が10に達するまで読み込むため、3件目（合計12）まで受け付ける
+			wantMaxQueued: 3,
+		},
+		{
+			name:          "records and bytes",
+			opts:          []PipelineStageOption{StageBuffer(2), StageBufferBytes(100, size)},
+			wantMaxQueued: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outputs, stages, err := New(
+				MapStage("Generator", &testSliceGenerator{records: records}),
+				MapStage("Map", &testSlowMapper{}, append(tt.opts, StageMaxParallel(1))...),
+			).Execute(context.Background())
+
+			assert.NoError(t, err)
+			assert.Equal(t, records, outputs)
+			assert.Equal(t, tt.wantMaxQueued, stages[1].MaxQueued)
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+)
+
+// グループごとに溜めたレコードに対して、途中でCombineを行う件数
+const combineThreshold = 1000
+
+// Mapperの出力を、後段のReducerに渡す前にグループごとに集約する
+// 出力は後段のReducerの入力として扱われるため、Reducerと同じ形式のレコードを返すこと
+// 同じグループに対して複数回呼び出され、その出力が再度入力に含まれることがあるため、結合則を満たす集約にのみ利用できる
+type Combiner interface {
+	Combine(ctx context.Context, group Group, inputs []Record) ([]Record, error)
+}
+
+// 関数をCombinerとして利用するための型
+type CombinerFunc func(ctx context.Context, group Group, inputs []Record) ([]Record, error)
+
+func (f CombinerFunc) Combine(ctx context.Context, group Group, inputs []Record) ([]Record, error) {
+	return f(ctx, group, inputs)
+}
+
+// Mapperの出力をグループごとに溜めておき、combinerで集約してから後段に渡す
+// 集約したレコードは、MapperがGroupCommitを出力した時点で実行中だった処理単位が全て完了した時点、
+// もしくはステージの全ての処理が完了した時点で、"<グループ>/*"を処理単位とする出力として後段に渡される
+// 後段のReducerが受け取るレコードの数を減らし、メモリ使用量を抑えるために利用する
+// Reducerに対しては何もしない
+func StageCombiner(combiner Combiner) PipelineStageOption {
+	return func(s *PipelineStage) {
+		s.combiner = combiner
+	}
+}
+
+// Mapperの出力をグループごとに溜めておくバッファ
+type combineBuffer struct {
+	combiner Combiner
+
+	mu      sync.Mutex
+	groups  map[string]*combineGroup
+	order   []string
+	running map[string]int // 実行を開始し、まだ出力をバッファに追加していない処理単位と、その数
+}
+
+type combineGroup struct {
+	group   Group
+	records []Record
+	// 保留しているGroupCommitと、出力の追加を待っている処理単位
+	commit  Record
+	waiting map[string]int
+}
+
+func newCombineBuffer(combiner Combiner) *combineBuffer {
+	if combiner == nil {
+		return nil
+	}
+	return &combineBuffer{
+		combiner: combiner,
+		groups:   map[string]*combineGroup{},
+		running:  map[string]int{},
+	}
+}
+
+// 処理単位の実行を開始した
+// 実行を開始した処理単位は、終了時に必ずaddを呼び出すこと
+func (b *combineBuffer) start(unit string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.running[unit]++
+}
+
+// 処理単位の出力をバッファに追加する
+// GroupCommitが含まれている場合は、その時点で実行中の処理単位が全て出力を追加するまでGroupCommitを保留し、
+// 保留が解除されたグループを集約した出力を返す
+// レコードが溜まっていないグループのGroupCommitは、そのまま後段に渡すレコードとしてpassに含める
+func (b *combineBuffer) add(ctx context.Context, unit string, records []Record) (pass []Record, outputs []Output) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if n := b.running[unit]; n > 1 {
+		b.running[unit] = n - 1
+	} else {
+		delete(b.running, unit)
+	}
+	for _, gr := range b.order {
+		if g := b.groups[gr]; g.waiting[unit] > 0 {
+			g.waiting[unit]--
+		}
+	}
+
+	for _, r := range records {
+		gr := r.Group().String()
+		g, ok := b.groups[gr]
+		if !ok {
+			g = &combineGroup{group: r.Group()}
+			b.groups[gr] = g
+			b.order = append(b.order, gr)
+		}
+
+		if _, ok := r.(groupCommit); ok {
+			// 同じグループのレコードを出力する可能性がある処理単位の完了を待つ
+			if g.commit == nil {
+				g.commit = r
+				g.waiting = map[string]int{}
+				for u, n := range b.running {
+					g.waiting[u] = n
+				}
+			}
+			continue
+		}
+
+		g.records = append(g.records, r)
+		// メモリ使用量を抑えるため、一定の件数が溜まったら途中で集約しておく
+		// 失敗した場合は集約せずに保持しておき、最後の集約で改めてエラーとして扱う
+		if len(g.records) >= combineThreshold {
+			if combined, err := b.run(ctx, g); err == nil {
+				g.records = combined
+			}
+		}
+	}
+
+	// 待っている処理単位が全て出力を追加したグループのGroupCommitを渡す
+	for _, gr := range b.order {
+		g := b.groups[gr]
+		if g.commit == nil || !g.ready() {
+			continue
+		}
+		if len(g.records) == 0 {
+			pass = append(pass, g.commit)
+		} else {
+			o := b.combine(ctx, g)
+			o.Records = append(o.Records, g.commit)
+			outputs = append(outputs, o)
+		}
+		g.records = nil
+		g.commit = nil
+		g.waiting = nil
+	}
+	return pass, outputs
+}
+
+// バッファに残っている全てのグループを集約した出力を返す
+// 保留しているGroupCommitも、集約した出力に含めて返す
+func (b *combineBuffer) flush(ctx context.Context) []Output {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	outputs := []Output{}
+	for _, gr := range b.order {
+		g := b.groups[gr]
+		if len(g.records) == 0 && g.commit == nil {
+			continue
+		}
+
+		o := Output{Unit: g.group.String() + "/" + na, Status: OutputStatusSuccess}
+		if len(g.records) > 0 {
+			o = b.combine(ctx, g)
+		}
+		if g.commit != nil {
+			o.Records = append(o.Records, g.commit)
+		}
+		outputs = append(outputs, o)
+		g.records = nil
+		g.commit = nil
+		g.waiting = nil
+	}
+	return outputs
+}
+
+func (g *combineGroup) ready() bool {
+	for _, n := range g.waiting {
+		if n > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *combineBuffer) combine(ctx context.Context, g *combineGroup) Output {
+	unit := g.group.String() + "/" + na
+
+	combined, err := b.run(ctx, g)
+	if err != nil {
+		return Output{
+			Unit:   unit,
+			Status: errorStatus(ctx, err),
+			Err:    err,
+		}
+	}
+	return Output{
+		Unit:    unit,
+		Status:  OutputStatusSuccess,
+		Records: combined,
+	}
+}
+
+// グループのレコードを集約する
+// Combinerには元のレコードを渡し、集約したレコードにはグループのメタデータを引き継ぐ
+func (b *combineBuffer) run(ctx context.Context, g *combineGroup) ([]Record, error) {
+	records, md, ok := unwrapRecords(g.records)
+	if ok {
+		ctx = withMetadata(ctx, md)
+	}
+
+	combined, err := b.combiner.Combine(ctx, g.group, records)
+	if err != nil || !ok {
+		return combined, err
+	}
+	return propagateMetadata(md.SourceStage, combined, md, true), nil
+}
@@ -0,0 +1,149 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// グループごとにIdentifierの数値を合計する
+func testSum(ctx context.Context, group Group, inputs []Record) ([]Record, error) {
+	sum := 0
+	for _, in := range inputs {
+		n, _ := strconv.Atoi(in.Identifier())
+		sum += n
+	}
+	return []Record{testRecord{group.String(), fmt.Sprint(sum)}}, nil
+}
+
+func TestStageCombiner(t *testing.T) {
+	records := []Record{}
+	for i := range 10 {
+		records = append(records, testRecord{[]string{"a", "b"}[i%2], fmt.Sprint(i)})
+	}
+
+	errCombine := errors.New("combine error")
+
+	tests := []struct {
+		name           string
+		combiner       Combiner
+		want           []Record
+		wantReduceSize int
+		wantMapOutputs []SummarizedOutput
+	}{
+		{
+			name: "no combiner",
+			want: []Record{
+				testRecord{"a", "5"},
+				testRecord{"b", "5"},
+			},
+			wantReduceSize: 5,
+		},
+		{
+			name:     "combiner",
+			combiner: CombinerFunc(testSum),
+			want: []Record{
+				testRecord{"a", "5"},
+				testRecord{"b", "5"},
+			},
+			// ASSERT: Reducerは集約されたレコードのみを受け取る
+			wantReduceSize: 1,
+			wantMapOutputs: []SummarizedOutput{
+				{Unit: "a/*", Status: OutputStatusSuccess, RecordCount: 1, GroupCount: 1},
+				{Unit: "b/*", Status: OutputStatusSuccess, RecordCount: 1, GroupCount: 1},
+			},
+		},
+		{
+			name: "combiner error",
+			combiner: CombinerFunc(func(ctx context.Context, group Group, inputs []Record) ([]Record, error) {
+				if group.String() == "b" {
+					return nil, errCombine
+				}
+				return testSum(ctx, group, inputs)
+			}),
+			want: []Record{
+				testRecord{"a", "5"},
+			},
+			wantReduceSize: 1,
+			wantMapOutputs: []SummarizedOutput{
+				{Unit: "a/*", Status: OutputStatusSuccess, RecordCount: 1, GroupCount: 1},
+				{Unit: "b/*", Status: OutputStatusError, Err: errCombine},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := []PipelineStageOption{StageMaxParallel(1)}
+			if tt.combiner != nil {
+				opts = append(opts, StageCombiner(tt.combiner))
+			}
+
+			reduceSizes := map[string]int{}
+			outputs, stages, err := New(
+				MapStage("Generator", &testSliceGenerator{records: records}),
+				MapFuncStage("Map", func(ctx context.Context, input Record) ([]Record, error) {
+					return []Record{testRecord{input.Group().String(), "1"}}, nil
+				}, opts...),
+				ReduceFuncStage("Reduce", func(ctx context.Context, group Group, inputs []Record) ([]Record, error) {
+					reduceSizes[group.String()] = len(inputs)
+					return testSum(ctx, group, inputs)
+				}, StageMaxParallel(1)),
+			).Execute(context.Background())
+			assert.NoError(t, err)
+
+			assert.ElementsMatch(t, tt.want, outputs)
+			for _, size := range reduceSizes {
+				assert.Equal(t, tt.wantReduceSize, size)
+			}
+			if tt.wantMapOutputs != nil {
+				// ASSERT: 集約した出力は、Mapperの処理単位の出力の後に"<グループ>/*"として出力される
+				mapOutputs := stages[1].Outputs
+				assert.Equal(t, tt.wantMapOutputs, mapOutputs[len(mapOutputs)-len(tt.wantMapOutputs):])
+			}
+		})
+	}
+}
+
+func TestCombineBuffer(t *testing.T) {
+	calls := 0
+	b := newCombineBuffer(CombinerFunc(func(ctx context.Context, group Group, inputs []Record) ([]Record, error) {
+		calls++
+		return testSum(ctx, group, inputs)
+	}))
+
+	for range 2500 {
+		pass, outputs := b.add(context.Background(), "a", []Record{testRecord{"a", "1"}})
+		assert.Empty(t, pass)
+		assert.Empty(t, outputs)
+	}
+	// ASSERT: 一定の件数が溜まるたびに途中で集約される
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, 502, len(b.groups["a"].records))
+
+	// ASSERT: GroupCommitを受け取ったら集約した出力を返し、レコードが溜まっていないグループのGroupCommitはそのまま渡す
+	pass, outputs := b.add(context.Background(), "commit", []Record{GroupCommit(GroupString("a")), GroupCommit(GroupString("empty"))})
+	assert.Equal(t, []Record{GroupCommit(GroupString("empty"))}, pass)
+	assert.Equal(t, []Output{
+		{Unit: "a/*", Status: OutputStatusSuccess, Records: []Record{testRecord{"a", "2500"}, GroupCommit(GroupString("a"))}},
+	}, outputs)
+	assert.Empty(t, b.flush(context.Background()))
+
+	// ASSERT: 実行中の処理単位がある間は、GroupCommitが保留される
+	b.start("fast")
+	b.start("slow")
+	pass, outputs = b.add(context.Background(), "fast", []Record{testRecord{"b", "1"}, GroupCommit(GroupString("b"))})
+	assert.Empty(t, pass)
+	assert.Empty(t, outputs)
+
+	// ASSERT: 待っていた処理単位の出力も含めて集約される
+	pass, outputs = b.add(context.Background(), "slow", []Record{testRecord{"b", "2"}})
+	assert.Empty(t, pass)
+	assert.Equal(t, []Output{
+		{Unit: "b/*", Status: OutputStatusSuccess, Records: []Record{testRecord{"b", "3"}, GroupCommit(GroupString("b"))}},
+	}, outputs)
+	assert.Empty(t, b.flush(context.Background()))
+}
@@ -0,0 +1,81 @@
+package pipeline
+
+// GroupCommitを後段に渡すタイミングを制御する
+// GroupCommitを受け取った時点で前段で実行中だった処理単位が全て出力を後段に渡すまで、GroupCommitを後段に渡さずに保留する
+type commitTracker struct {
+	rt      *stageRuntime
+	pending []*pendingCommit
+}
+
+type pendingCommit struct {
+	commit  Record
+	waiting map[string]int // 出力を後段に渡すのを待っている処理単位
+}
+
+func newCommitTracker(rt *stageRuntime) *commitTracker {
+	return &commitTracker{rt: rt}
+}
+
+// 前段の処理単位の出力を受け取り、後段に渡すレコードを返す
+// 出力をstageRuntime.outputに渡した後に、その戻り値とともに呼び出すこと
+func (t *commitTracker) observe(o Output, started bool) []Record {
+	if t == nil {
+		return o.Records
+	}
+
+	// 出力を後段に渡した処理単位は、待機対象から外す
+	// 実行を開始せずにスキップされた処理単位の出力では、同じ名前の実行中の処理単位を待機対象から外さない
+	for _, p := range t.pending {
+		if started && p.waiting[o.Unit] > 0 {
+			p.waiting[o.Unit]--
+		}
+	}
+
+	records := []Record{}
+	for _, r := range o.Records {
+		if _, ok := r.(groupCommit); !ok {
+			records = append(records, r)
+			continue
+		}
+
+		waiting := t.rt.undeliveredUnits()
+		t.pending = append(t.pending, &pendingCommit{commit: r, waiting: waiting})
+	}
+
+	// 待機している処理単位が全て完了したGroupCommitを、受け取った順に後段に渡す
+	pending := []*pendingCommit{}
+	for _, p := range t.pending {
+		if p.done() {
+			records = append(records, p.commit)
+		} else {
+			pending = append(pending, p)
+		}
+	}
+	t.pending = pending
+
+	return records
+}
+
+// 保留しているGroupCommitを全て返す
+// 前段の全ての処理単位が完了した時点で呼び出す
+func (t *commitTracker) flush() []Record {
+	if t == nil {
+		return nil
+	}
+
+	records := []Record{}
+	for _, p := range t.pending {
+		records = append(records, p.commit)
+	}
+	t.pending = nil
+	return records
+}
+
+func (p *pendingCommit) done() bool {
+	for _, n := range p.waiting {
+		if n > 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,149 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGroupCommitWithCount(t *testing.T) {
+	tests := []struct {
+		name    string
+		records []Record
+		want    []Record
+		late    int
+	}{
+		{
+			name: "commit before records",
+			records: []Record{
+				// ASSERT: GroupCommitが先に届いても、宣言した件数のレコードが揃うまで処理を開始しない
+				GroupCommitWithCount(GroupString("group1"), 2),
+				testRecord{"group1", "1"},
+				testRecord{"group1", "2"},
+				testRecord{"group2", "3"},
+			},
+			want: []Record{
+				testRecord{"group1", "2"},
+				testRecord{"group2", "1"},
+			},
+		},
+		{
+			name: "late records",
+			records: []Record{
+				testRecord{"group1", "1"},
+				GroupCommit(GroupString("group1")),
+				// ASSERT: 処理を開始したグループのレコードは、遅れて到着したレコードとして数えられる
+				testRecord{"group1", "2"},
+				testRecord{"group1", "3"},
+			},
+			want: []Record{
+				testRecord{"group1", "1"},
+			},
+			late: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outputs, stages, err := New(
+				MapStage("Generator", &testSliceGenerator{records: tt.records}),
+				ReduceFuncStage("Count", testCountReducer),
+			).Execute(context.Background())
+
+			assert.NoError(t, err)
+			assert.ElementsMatch(t, tt.want, outputs)
+			assert.Equal(t, tt.late, stages[1].LateRecords)
+		})
+	}
+}
+
+func TestStageGroupCommitWaitInFlight(t *testing.T) {
+	tests := []struct {
+		name    string
+		mapOpts []PipelineStageOption
+		opts    []PipelineStageOption
+		want    []Record
+		late    int
+	}{
+		{
+			name: "default",
+			// ASSERT: 最初のGroupCommitで処理を開始し、他の処理単位のレコードは遅れて到着する
+			want: []Record{testRecord{"group1", "1"}},
+			late: 1,
+		},
+		{
+			name: "wait in-flight",
+			opts: []PipelineStageOption{StageGroupCommitWaitInFlight()},
+			// ASSERT: 実行中の処理単位が完了するまでGroupCommitが保留される
+			want: []Record{testRecord{"group1", "2"}},
+		},
+		{
+			name: "combiner",
+			mapOpts: []PipelineStageOption{StageCombiner(CombinerFunc(func(ctx context.Context, group Group, inputs []Record) ([]Record, error) {
+				return inputs, nil
+			}))},
+			opts: []PipelineStageOption{StageGroupCommitWaitInFlight()},
+			// ASSERT: Combinerも実行中の処理単位が完了するまでGroupCommitを保留する
+			want: []Record{testRecord{"group1", "2"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outputs, stages, err := New(
+				MapStage("Generator", &testSliceGenerator{records: []Record{
+					testRecord{"fast", "1"},
+					testRecord{"slow", "2"},
+				}}),
+				MapFuncStage("Split", func(ctx context.Context, input Record) ([]Record, error) {
+					if input.Group().String() == "fast" {
+						time.Sleep(10 * time.Millisecond)
+						return []Record{
+							testRecord{"group1", input.Identifier()},
+							GroupCommit(GroupString("group1")),
+						}, nil
+					}
+					time.Sleep(50 * time.Millisecond)
+					return []Record{testRecord{"group1", input.Identifier()}}, nil
+				}, append([]PipelineStageOption{StageMaxParallel(2)}, tt.mapOpts...)...),
+				ReduceFuncStage("Count", testCountReducer, tt.opts...),
+			).Execute(context.Background())
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, outputs)
+			assert.Equal(t, tt.late, stages[2].LateRecords)
+		})
+	}
+}
+
+func testCountReducer(ctx context.Context, group Group, inputs []Record) ([]Record, error) {
+	return []Record{testRecord{group.String(), fmt.Sprint(len(inputs))}}, nil
+}
+
+func TestCommitTracker(t *testing.T) {
+	rt := newStageRuntime(MapStage("Map", &testIdentityMapper{}), newRunControl(), nil)
+	tracker := newCommitTracker(rt)
+
+	rt.started("a")
+	rt.started("b")
+	// bの処理は完了しているが、出力はまだ後段に渡されていない
+	rt.finished("b")
+	rt.finished("a")
+
+	a := Output{Unit: "a", Records: []Record{testRecord{"group1", "1"}, GroupCommit(GroupString("group1"))}}
+	// ASSERT: 出力を後段に渡していない処理単位がある間は、GroupCommitが保留される
+	assert.Equal(t, []Record{testRecord{"group1", "1"}}, tracker.observe(a, rt.output(a)))
+
+	rt.bypass("b")
+	skipped := Output{Unit: "b", Status: OutputStatusSkipped}
+	// ASSERT: 実行を開始せずにスキップされた同じ名前の処理単位の出力では、GroupCommitが渡されない
+	assert.Empty(t, tracker.observe(skipped, rt.output(skipped)))
+	assert.Equal(t, map[string]int{"b": 1}, rt.undeliveredUnits())
+
+	b := Output{Unit: "b", Records: []Record{testRecord{"group1", "2"}}}
+	// ASSERT: 全ての処理単位の出力を渡した後に、GroupCommitが渡される
+	assert.Equal(t, []Record{testRecord{"group1", "2"}, GroupCommit(GroupString("group1"))}, tracker.observe(b, rt.output(b)))
+	assert.Empty(t, tracker.flush())
+	assert.Empty(t, rt.undeliveredUnits())
+}
@@ -0,0 +1,105 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+)
+
+const (
+	defaultDedupExpectedItems     = 100000
+	defaultDedupFalsePositiveRate = 0.01
+)
+
+type DedupConfig struct {
+	// 重複を判定するキー。nilの場合はRecordKey
+	Key func(r Record) string
+	// trueの場合は、全てのキーを保持する代わりにBloom filterで判定して、メモリ使用量を一定に抑える
+	// 重複していないレコードを重複と判定して除外することがある
+	Approximate bool
+	// Approximateの場合に想定するレコードの件数。0の場合は100000件
+	ExpectedItems int
+	// Approximateの場合に、ExpectedItems件のレコードを処理した時点での偽陽性率。0の場合は0.01
+	FalsePositiveRate float64
+	// 重複したレコードを除外する代わりに、先に受け取ったレコードとまとめる関数
+	// 指定した場合はReducerとしてグループごとに全てのレコードを受け取ってからまとめるため、同じグループ内のレコードのみが対象になる
+	// Approximateは無視される
+	Merge func(existing Record, duplicate Record) Record
+}
+
+// キーが同じレコードを重複として除外するステージを組み立てる
+// 除外されたレコードはOutputStatusFilteredとして集計される
+func DedupStage(name string, config DedupConfig, opts ...PipelineStageOption) *PipelineStage {
+	if config.Key == nil {
+		config.Key = RecordKey
+	}
+
+	if config.Merge != nil {
+		return ReduceFuncStage(name, func(ctx context.Context, group Group, inputs []Record) ([]Record, error) {
+			return mergeDuplicates(inputs, config.Key, config.Merge), nil
+		}, opts...)
+	}
+
+	if config.ExpectedItems <= 0 {
+		config.ExpectedItems = defaultDedupExpectedItems
+	}
+	if config.FalsePositiveRate <= 0 || config.FalsePositiveRate >= 1 {
+		config.FalsePositiveRate = defaultDedupFalsePositiveRate
+	}
+
+	return Stage(newStatefulMapProcessor(name, func() Mapper {
+		var seen keySet = &exactKeySet{keys: map[string]struct{}{}}
+		if config.Approximate {
+			seen = newBloomFilter(config.ExpectedItems, config.FalsePositiveRate)
+		}
+		return &dedupMapper{key: config.Key, seen: seen}
+	}), opts...)
+}
+
+type keySet interface {
+	// キーを追加し、追加前に既に追加済みだったかどうかを返す
+	testAndAdd(key string) bool
+}
+
+type exactKeySet struct {
+	mu   sync.Mutex
+	keys map[string]struct{}
+}
+
+func (s *exactKeySet) testAndAdd(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.keys[key]; ok {
+		return true
+	}
+	s.keys[key] = struct{}{}
+	return false
+}
+
+type dedupMapper struct {
+	key  func(r Record) string
+	seen keySet
+}
+
+func (m *dedupMapper) Map(ctx context.Context, input Record) ([]Record, error) {
+	if m.seen.testAndAdd(m.key(input)) {
+		return nil, ErrFiltered
+	}
+	return []Record{input}, nil
+}
+
+// 先に受け取ったレコードの順序を保ったまま、キーが同じレコードをまとめる
+func mergeDuplicates(inputs []Record, key func(r Record) string, merge func(existing Record, duplicate Record) Record) []Record {
+	outputs := []Record{}
+	index := map[string]int{}
+	for _, in := range inputs {
+		k := key(in)
+		if i, ok := index[k]; ok {
+			outputs[i] = merge(outputs[i], in)
+			continue
+		}
+		index[k] = len(outputs)
+		outputs = append(outputs, in)
+	}
+	return outputs
+}
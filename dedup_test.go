@@ -0,0 +1,108 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDedupStage(t *testing.T) {
+	records := []Record{
+		testRecord{"group1", "1"},
+		testRecord{"group1", "2"},
+		testRecord{"group1", "1"},
+		testRecord{"group2", "1"},
+		testRecord{"group1", "2"},
+	}
+
+	tests := []struct {
+		name         string
+		config       DedupConfig
+		want         []Record
+		wantFiltered int
+	}{
+		{
+			name:   "exact",
+			config: DedupConfig{},
+			want: []Record{
+				testRecord{"group1", "1"},
+				testRecord{"group1", "2"},
+				testRecord{"group2", "1"},
+			},
+			wantFiltered: 2,
+		},
+		{
+			name:   "approximate",
+			config: DedupConfig{Approximate: true, ExpectedItems: 100},
+			want: []Record{
+				testRecord{"group1", "1"},
+				testRecord{"group1", "2"},
+				testRecord{"group2", "1"},
+			},
+			wantFiltered: 2,
+		},
+		{
+			name: "custom key",
+			config: DedupConfig{
+				Key: func(r Record) string { return r.Identifier() },
+			},
+			want: []Record{
+				testRecord{"group1", "1"},
+				testRecord{"group1", "2"},
+			},
+			wantFiltered: 3,
+		},
+		{
+			name: "merge",
+			config: DedupConfig{
+				Merge: func(existing Record, duplicate Record) Record {
+					return testRecord{existing.Group().String(), existing.Identifier() + "+" + duplicate.Identifier()}
+				},
+			},
+			want: []Record{
+				testRecord{"group1", "1+1"},
+				testRecord{"group1", "2+2"},
+				testRecord{"group2", "1"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pp := New(
+				MapStage("Generator", &testSliceGenerator{records: records}),
+				DedupStage("Dedup", tt.config, StageMaxParallel(1)),
+			)
+
+			// ASSERT: 実行ごとに重複の判定がリセットされる
+			for range 2 {
+				outputs, stages, err := pp.Execute(context.Background())
+				assert.NoError(t, err)
+				assert.ElementsMatch(t, tt.want, outputs)
+				assert.Equal(t, tt.wantFiltered, stages[1].Count(OutputStatusFiltered))
+			}
+		})
+	}
+}
+
+func TestBloomFilter(t *testing.T) {
+	f := newBloomFilter(1000, 0.01)
+
+	for i := range 1000 {
+		f.testAndAdd(fmt.Sprintf("added-%d", i))
+	}
+	for i := range 1000 {
+		// ASSERT: 追加したキーは必ず追加済みと判定される
+		assert.True(t, f.testAndAdd(fmt.Sprintf("added-%d", i)))
+	}
+
+	falsePositives := 0
+	for i := range 100 {
+		if f.testAndAdd(fmt.Sprintf("other-%d", i)) {
+			falsePositives++
+		}
+	}
+	// ASSERT: 追加していないキーを追加済みと判定する割合が、おおよそ指定した偽陽性率に収まる
+	assert.Less(t, falsePositives, 5)
+}
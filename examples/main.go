@@ -125,19 +125,6 @@ func main() {
 
 	fmt.Println("--- Executions ---")
 	for _, stage := range stages {
-		successCount := 0
-		errorCount := 0
-		recordCount := 0
-		for _, o := range stage.Outputs {
-			if o.Status == pipeline.OutputStatusSuccess {
-				successCount++
-			}
-			if o.Status == pipeline.OutputStatusError {
-				errorCount++
-			}
-			recordCount += o.RecordCount
-		}
-
-		fmt.Printf("Stage %s: %d records generated, %d success, %d errors\n", stage.Name, recordCount, successCount, errorCount)
+		fmt.Printf("Stage %s: %d records generated, %d success, %d errors\n", stage.Name, stage.RecordCount(), stage.Count(pipeline.OutputStatusSuccess), stage.FailureCount())
 	}
 }
@@ -0,0 +1,48 @@
+package pipeline
+
+import "context"
+
+// 関数をMapperとして利用するための型
+type MapperFunc func(ctx context.Context, input Record) ([]Record, error)
+
+func (f MapperFunc) Map(ctx context.Context, input Record) ([]Record, error) {
+	return f(ctx, input)
+}
+
+// 関数をReducerとして利用するための型
+type ReducerFunc func(ctx context.Context, group Group, inputs []Record) ([]Record, error)
+
+func (f ReducerFunc) Reduce(ctx context.Context, group Group, inputs []Record) ([]Record, error) {
+	return f(ctx, group, inputs)
+}
+
+// 関数を元にMapperのステージを組み立てる
+// 1件のレコードから0件以上のレコードを出力できる
+func MapFuncStage(name string, fn func(ctx context.Context, input Record) ([]Record, error), opts ...PipelineStageOption) *PipelineStage {
+	return MapStage(name, MapperFunc(fn), opts...)
+}
+
+// 関数を元にReducerのステージを組み立てる
+func ReduceFuncStage(name string, fn func(ctx context.Context, group Group, inputs []Record) ([]Record, error), opts ...PipelineStageOption) *PipelineStage {
+	return ReduceStage(name, ReducerFunc(fn), opts...)
+}
+
+// keepがtrueを返したレコードのみを後段に渡すステージを組み立てる
+// 除外されたレコードはOutputStatusFilteredとして、エラーとは別に集計される
+func FilterStage(name string, keep func(r Record) bool, opts ...PipelineStageOption) *PipelineStage {
+	return MapFuncStage(name, func(ctx context.Context, input Record) ([]Record, error) {
+		if !keep(input) {
+			return nil, ErrFiltered
+		}
+		return []Record{input}, nil
+	}, opts...)
+}
+
+// レコードごとにfnを呼び出し、レコードをそのまま後段に渡すステージを組み立てる
+// ログの出力やメトリクスの記録など、副作用のみを持つ処理に利用する
+func TapStage(name string, fn func(ctx context.Context, r Record), opts ...PipelineStageOption) *PipelineStage {
+	return MapFuncStage(name, func(ctx context.Context, input Record) ([]Record, error) {
+		fn(ctx, input)
+		return []Record{input}, nil
+	}, opts...)
+}
@@ -0,0 +1,67 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFuncStages(t *testing.T) {
+	errOdd := errors.New("odd")
+
+	records := []Record{
+		testRecord{"group1", "1"},
+		testRecord{"group1", "2"},
+		testRecord{"group2", "3"},
+		testRecord{"skip", "4"},
+	}
+
+	var mu sync.Mutex
+	tapped := []string{}
+
+	_, stages, err := New(
+		MapStage("Generator", &testSliceGenerator{records: records}),
+		FilterStage("Filter", func(r Record) bool {
+			return r.Group().String() != "skip"
+		}),
+		MapFuncStage("Split", func(ctx context.Context, input Record) ([]Record, error) {
+			if input.Identifier() == "3" {
+				return nil, errOdd
+			}
+			return []Record{
+				testRecord{input.Group().String(), input.Identifier() + "_a"},
+				testRecord{input.Group().String(), input.Identifier() + "_b"},
+			}, nil
+		}),
+		TapStage("Tap", func(ctx context.Context, r Record) {
+			mu.Lock()
+			defer mu.Unlock()
+			tapped = append(tapped, r.Identifier())
+		}),
+		ReduceFuncStage("Count", func(ctx context.Context, group Group, inputs []Record) ([]Record, error) {
+			return []Record{testRecord{group.String(), fmt.Sprint(len(inputs))}}, nil
+		}),
+	).Execute(context.Background())
+	assert.NoError(t, err)
+
+	// ASSERT: 除外されたレコードはエラーとは別に集計される
+	assert.Equal(t, 3, stages[1].Count(OutputStatusSuccess))
+	assert.Equal(t, 1, stages[1].Count(OutputStatusFiltered))
+	assert.Equal(t, 0, stages[1].FailureCount())
+
+	assert.Equal(t, 2, stages[2].Count(OutputStatusSuccess))
+	assert.Equal(t, 1, stages[2].FailureCount())
+
+	sort.Strings(tapped)
+	assert.Equal(t, []string{"1_a", "1_b", "2_a", "2_b"}, tapped)
+	assert.Equal(t, 4, stages[3].RecordCount())
+
+	assert.Equal(t, []SummarizedOutput{
+		{Unit: "group1", Status: OutputStatusSuccess, RecordCount: 1, GroupCount: 1},
+	}, stages[4].Outputs)
+}
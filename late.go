@@ -0,0 +1,47 @@
+package pipeline
+
+import "context"
+
+// Reducerで、処理を開始したグループに遅れて到着したレコードの扱い
+// 遅れて到着したレコードは、全てのレコードを受け取った後に"<グループ>/late"を処理単位として扱われる
+type LateRecordPolicy string
+
+const (
+	LateRecordDrop       LateRecordPolicy = "Drop"       // 破棄して、処理単位をOutputStatusFilteredとして出力する（デフォルト）
+	LateRecordReduce     LateRecordPolicy = "Reduce"     // 遅れて到着したレコードだけで、もう一度Reducerを呼び出す
+	LateRecordDeadLetter LateRecordPolicy = "DeadLetter" // DeadLetterに渡す
+)
+
+type LateRecordConfig struct {
+	Policy LateRecordPolicy
+	// LateRecordDeadLetterの場合に、遅れて到着したレコードをグループごとに受け取る関数
+	// エラーを返した場合は、処理単位の失敗として扱われる。nilの場合はLateRecordDropと同様に破棄する
+	DeadLetter func(ctx context.Context, group Group, records []Record) error
+}
+
+// Reducerで、GroupCommitを受け取って処理を開始したグループに遅れて到着したレコードの扱いを設定する
+// Mapperに対しては何もしない
+func StageLateRecords(config LateRecordConfig) PipelineStageOption {
+	return func(s *PipelineStage) {
+		if p, ok := s.processor.(*reduceProcessor); ok {
+			p.lateRecords = config
+		}
+	}
+}
+
+// 遅れて到着したレコードを扱う処理単位の名前
+func lateUnit(group Group) string {
+	return group.String() + "/late"
+}
+
+// 遅れて到着したレコードを、Reducerを呼び出さずに処理するかどうか
+func (c LateRecordConfig) drop() bool {
+	switch c.Policy {
+	case LateRecordReduce:
+		return false
+	case LateRecordDeadLetter:
+		return c.DeadLetter == nil
+	default:
+		return true
+	}
+}
@@ -0,0 +1,97 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStageLateRecords(t *testing.T) {
+	errDeadLetter := errors.New("dead letter error")
+
+	tests := []struct {
+		name           string
+		config         LateRecordConfig
+		deadLetterErr  error
+		want           []Record
+		wantLate       SummarizedOutput
+		wantDeadLetter []Record
+	}{
+		{
+			name: "drop",
+			want: []Record{
+				testRecord{"group1", "1"},
+				testRecord{"group2", "1"},
+			},
+			wantLate: SummarizedOutput{Unit: "group1/late", Status: OutputStatusFiltered, Late: 2},
+		},
+		{
+			name:   "reduce",
+			config: LateRecordConfig{Policy: LateRecordReduce},
+			want: []Record{
+				testRecord{"group1", "1"},
+				// ASSERT: 遅れて到着したレコードだけで、もう一度Reducerが呼び出される
+				testRecord{"group1", "2"},
+				testRecord{"group2", "1"},
+			},
+			wantLate: SummarizedOutput{Unit: "group1/late", Status: OutputStatusSuccess, RecordCount: 1, GroupCount: 1, Late: 2},
+		},
+		{
+			name:   "dead letter",
+			config: LateRecordConfig{Policy: LateRecordDeadLetter},
+			want: []Record{
+				testRecord{"group1", "1"},
+				testRecord{"group2", "1"},
+			},
+			wantLate: SummarizedOutput{Unit: "group1/late", Status: OutputStatusSuccess, Late: 2},
+			wantDeadLetter: []Record{
+				testRecord{"group1", "2"},
+				testRecord{"group1", "3"},
+			},
+		},
+		{
+			name:          "dead letter error",
+			config:        LateRecordConfig{Policy: LateRecordDeadLetter},
+			deadLetterErr: errDeadLetter,
+			want: []Record{
+				testRecord{"group1", "1"},
+				testRecord{"group2", "1"},
+			},
+			wantLate: SummarizedOutput{Unit: "group1/late", Status: OutputStatusError, Err: errDeadLetter, Late: 2},
+			wantDeadLetter: []Record{
+				testRecord{"group1", "2"},
+				testRecord{"group1", "3"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var deadLetter []Record
+			if tt.config.Policy == LateRecordDeadLetter {
+				tt.config.DeadLetter = func(ctx context.Context, group Group, records []Record) error {
+					deadLetter = append(deadLetter, records...)
+					return tt.deadLetterErr
+				}
+			}
+
+			outputs, stages, err := New(
+				MapStage("Generator", &testSliceGenerator{records: []Record{
+					testRecord{"group1", "1"},
+					GroupCommit(GroupString("group1")),
+					testRecord{"group1", "2"},
+					testRecord{"group1", "3"},
+					testRecord{"group2", "4"},
+				}}),
+				ReduceFuncStage("Count", testCountReducer, StageLateRecords(tt.config)),
+			).Execute(context.Background())
+
+			assert.NoError(t, err)
+			assert.ElementsMatch(t, tt.want, outputs)
+			assert.Contains(t, stages[1].Outputs, tt.wantLate)
+			assert.Equal(t, 2, stages[1].LateRecords)
+			assert.Equal(t, tt.wantDeadLetter, deadLetter)
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	defaultAdaptiveLatencyTolerance = 2.0
+	defaultAdaptiveBackoffRatio     = 0.9
+)
+
+// 並列数を実行時に動的に調整するための設定
+// AIMD (Additive Increase / Multiplicative Decrease) により、
+// 処理が成功し続ける間は並列数を徐々に増やし、失敗もしくはレイテンシの悪化を検知したら一定の割合で減らす
+type AdaptiveConcurrencyConfig struct {
+	// 並列数の下限。0の場合は1
+	MinLimit int
+	// 並列数の上限。0の場合はMinLimitの10倍
+	MaxLimit int
+	// 並列数の初期値。0の場合はMinLimit
+	InitialLimit int
+	// 処理単位のレイテンシがこの値を超えた場合に混雑とみなす
+	// 0の場合は、観測した最小のレイテンシのLatencyTolerance倍を超えた場合に混雑とみなす
+	LatencyThreshold time.Duration
+	// LatencyThresholdが0の場合に利用する、最小のレイテンシに対する許容倍率。0の場合は2.0
+	LatencyTolerance float64
+	// 失敗もしくは混雑を検知した際に並列数に掛ける割合。0の場合は0.9
+	BackoffRatio float64
+}
+
+// 並列数の変化
+type ConcurrencyLimitChange struct {
+	At    time.Time
+	Limit int
+}
+
+type adaptiveLimiter struct {
+	config AdaptiveConcurrencyConfig
+
+	mu         sync.Mutex
+	limit      float64
+	inFlight   int
+	minLatency time.Duration
+	changed    chan struct{} // 実行枠が空いた際にcloseされる
+	history    []ConcurrencyLimitChange
+}
+
+func newAdaptiveLimiter(config AdaptiveConcurrencyConfig) *adaptiveLimiter {
+	if config.MinLimit <= 0 {
+		config.MinLimit = 1
+	}
+	if config.MaxLimit <= 0 {
+		config.MaxLimit = config.MinLimit * 10
+	}
+	if config.MaxLimit < config.MinLimit {
+		config.MaxLimit = config.MinLimit
+	}
+	if config.InitialLimit <= 0 {
+		config.InitialLimit = config.MinLimit
+	}
+	config.InitialLimit = min(max(config.InitialLimit, config.MinLimit), config.MaxLimit)
+	if config.LatencyTolerance <= 0 {
+		config.LatencyTolerance = defaultAdaptiveLatencyTolerance
+	}
+	if config.BackoffRatio <= 0 || config.BackoffRatio >= 1 {
+		config.BackoffRatio = defaultAdaptiveBackoffRatio
+	}
+
+	return &adaptiveLimiter{
+		config:  config,
+		limit:   float64(config.InitialLimit),
+		changed: make(chan struct{}),
+		history: []ConcurrencyLimitChange{
+			{At: time.Now(), Limit: config.InitialLimit},
+		},
+	}
+}
+
+// 実行枠が空くまで待機する
+func (l *adaptiveLimiter) acquire(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+		if l.inFlight < int(l.limit) {
+			l.inFlight++
+			l.mu.Unlock()
+			return nil
+		}
+		changed := l.changed
+		l.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// 実行枠を解放し、処理単位のレイテンシと結果をもとに並列数を調整する
+// 成功・失敗のいずれでもないステータス（キャンセルやスキップなど）は調整に利用しない
+func (l *adaptiveLimiter) release(latency time.Duration, status OutputStatus) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.inFlight--
+
+	switch {
+	case status.IsFailure() || l.congested(latency):
+		l.limit = max(l.limit*l.config.BackoffRatio, float64(l.config.MinLimit))
+	case status == OutputStatusSuccess:
+		l.limit = min(l.limit+1/l.limit, float64(l.config.MaxLimit))
+	}
+
+	if status == OutputStatusSuccess && (l.minLatency == 0 || latency < l.minLatency) {
+		l.minLatency = latency
+	}
+
+	if current := int(l.limit); current != l.history[len(l.history)-1].Limit {
+		l.history = append(l.history, ConcurrencyLimitChange{At: time.Now(), Limit: current})
+	}
+
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+func (l *adaptiveLimiter) congested(latency time.Duration) bool {
+	if l.config.LatencyThreshold > 0 {
+		return latency > l.config.LatencyThreshold
+	}
+	if l.minLatency == 0 {
+		return false
+	}
+	return float64(latency) > float64(l.minLatency)*l.config.LatencyTolerance
+}
+
+func (l *adaptiveLimiter) currentLimit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return int(l.limit)
+}
+
+func (l *adaptiveLimiter) changes() []ConcurrencyLimitChange {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return append([]ConcurrencyLimitChange{}, l.history...)
+}
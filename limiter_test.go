@@ -0,0 +1,124 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_adaptiveLimiter(t *testing.T) {
+	type step struct {
+		latency   time.Duration
+		status    OutputStatus
+		wantLimit int
+	}
+	tests := []struct {
+		name        string
+		config      AdaptiveConcurrencyConfig
+		steps       []step
+		wantHistory []int
+	}{
+		{
+			name:   "additive increase, multiplicative decrease",
+			config: AdaptiveConcurrencyConfig{MinLimit: 1, MaxLimit: 3, InitialLimit: 2, BackoffRatio: 0.5},
+			steps: []step{
+				{latency: time.Millisecond, status: OutputStatusSuccess, wantLimit: 2}, // 2.5
+				{latency: time.Millisecond, status: OutputStatusSuccess, wantLimit: 2}, // 2.9
+				{latency: time.Millisecond, status: OutputStatusSuccess, wantLimit: 3}, // 3.24 -> 3 (上限)
+				{latency: time.Millisecond, status: OutputStatusSkipped, wantLimit: 3}, // ASSERT: スキップは調整に利用されない
+				{latency: time.Millisecond, status: OutputStatusError, wantLimit: 1},   // 1.5
+				{latency: time.Millisecond, status: OutputStatusTimeout, wantLimit: 1}, // 0.75 -> 1 (下限)
+				{latency: time.Millisecond, status: OutputStatusSuccess, wantLimit: 2}, // 2
+			},
+			wantHistory: []int{2, 3, 1, 2},
+		},
+		{
+			name:   "latency",
+			config: AdaptiveConcurrencyConfig{MinLimit: 1, MaxLimit: 10, InitialLimit: 4, BackoffRatio: 0.5},
+			steps: []step{
+				{latency: 10 * time.Millisecond, status: OutputStatusSuccess, wantLimit: 4},
+				// 最小のレイテンシの2倍を超えると混雑とみなす
+				{latency: 30 * time.Millisecond, status: OutputStatusSuccess, wantLimit: 2},
+				{latency: 15 * time.Millisecond, status: OutputStatusSuccess, wantLimit: 2},
+			},
+			wantHistory: []int{4, 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newAdaptiveLimiter(tt.config)
+
+			for _, s := range tt.steps {
+				assert.NoError(t, l.acquire(context.Background()))
+				l.release(s.latency, s.status)
+				assert.Equal(t, s.wantLimit, l.currentLimit())
+			}
+
+			history := []int{}
+			for _, c := range l.changes() {
+				history = append(history, c.Limit)
+			}
+			assert.Equal(t, tt.wantHistory, history)
+		})
+	}
+}
+
+func Test_adaptiveLimiter_acquire(t *testing.T) {
+	l := newAdaptiveLimiter(AdaptiveConcurrencyConfig{MinLimit: 1, MaxLimit: 1})
+	assert.NoError(t, l.acquire(context.Background()))
+
+	// 実行枠が空いていない場合は待機する
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, l.acquire(ctx), context.DeadlineExceeded)
+
+	// 実行枠が解放されると取得できる
+	acquired := make(chan error)
+	go func() {
+		acquired <- l.acquire(context.Background())
+	}()
+	l.release(time.Millisecond, OutputStatusSuccess)
+	assert.NoError(t, <-acquired)
+}
+
+func TestStageAdaptiveParallel(t *testing.T) {
+	_, stages, err := New(
+		MapStage("Generator", &testSliceGenerator{records: []Record{
+			testRecord{"group1", "id1"},
+			testRecord{"group1", "id2"},
+			testRecord{"error", "id3"},
+		}}),
+		MapStage("Map", &testMapper{}, StageAdaptiveParallel(AdaptiveConcurrencyConfig{MinLimit: 1, MaxLimit: 4, InitialLimit: 2})),
+	).Execute(context.Background())
+	assert.NoError(t, err)
+
+	assert.Len(t, stages[1].Outputs, 3)
+	assert.NotEmpty(t, stages[1].ConcurrencyLimits)
+	assert.Equal(t, 2, stages[1].ConcurrencyLimits[0].Limit)
+	assert.Empty(t, stages[0].ConcurrencyLimits)
+}
+
+func TestStageAdaptiveParallel_MaxParallel(t *testing.T) {
+	records := make([]Record, 20)
+	for i := range records {
+		records[i] = testRecord{"group1", fmt.Sprintf("id%d", i)}
+	}
+	_, stages, err := New(
+		MapStage("Generator", &testSliceGenerator{records: records}),
+		MapStage("Map", &testMapper{},
+			StageMaxParallel(2),
+			StageAdaptiveParallel(AdaptiveConcurrencyConfig{MinLimit: 3, MaxLimit: 10, InitialLimit: 5}),
+		),
+	).Execute(context.Background())
+	assert.NoError(t, err)
+
+	assert.Len(t, stages[1].Outputs, 20)
+	assert.NotEmpty(t, stages[1].ConcurrencyLimits)
+	for _, c := range stages[1].ConcurrencyLimits {
+		// ASSERT: StageMaxParallelの値を超えない
+		assert.LessOrEqual(t, c.Limit, 2)
+	}
+}
@@ -2,8 +2,7 @@ package pipeline
 
 import (
 	"context"
-
-	"golang.org/x/sync/errgroup"
+	"time"
 )
 
 // <group1, id1> -> Mapper() -> list(<group2, id2>)
@@ -44,55 +43,81 @@ func (p *mapProcessor) Type() ProcessorType {
 func (p *mapProcessor) Process(ctx context.Context, inputs <-chan Record, abort chan<- error) <-chan Output {
 	outputs := make(chan Output)
 
-	eg, ctx := errgroup.WithContext(ctx)
-	if p.maxParallel > 0 {
-		eg.SetLimit(p.maxParallel)
-	}
+	rt := stageRuntimeFrom(ctx)
+	combiner := rt.combineBuffer()
 
-	go func() {
-		for in := range inputs {
-			// GroupCommitは無視する
-			if _, ok := in.(groupCommit); ok {
-				continue
+	run := func(ctx context.Context, in Record) error {
+		// GroupCommitは無視する
+		if _, ok := in.(groupCommit); ok {
+			return nil
+		}
+
+		rt.received()
+
+		// 並列数を動的に調整する場合や重み付きで制限する場合は、実行枠が空くまで待機する
+		slot := rt.acquire(ctx, in)
+
+		unit := RecordKey(in)
+
+		running, err := rt.begin(ctx, unit, true)
+		if err != nil {
+			slot.release(0, OutputStatusSkipped)
+			outputs <- Output{
+				Unit:   unit,
+				Status: OutputStatusSkipped,
+				Err:    err,
 			}
+			return nil
+		}
 
-			eg.Go(func() (err error) {
-				defer func() {
-					if err != nil {
-						outputs <- Output{
-							Unit:   RecordKey(in),
-							Status: OutputStatusError,
-							Err:    err,
-						}
-						// abortIfAnyErrorがtrueの場合のみ、errを返して全体を止める
-						if !p.abortIfAnyError {
-							err = nil
-						}
-					}
-				}()
-
-				select {
-				case <-ctx.Done():
-					return ctx.Err()
-				default:
-				}
+		if combiner != nil {
+			combiner.start(unit)
+		}
 
-				o, err := p.mapper.Map(ctx, in)
-				if err != nil {
-					return err
-				}
+		start := time.Now()
+		output, err := p.mapRecord(ctx, unit, in)
+		slot.release(time.Since(start), output.Status)
+		// 失敗の許容量を超えた場合もerrを返して全体を止める
+		if budgetErr := running.end(output); err == nil {
+			err = budgetErr
+		}
 
-				outputs <- Output{
-					Unit:    RecordKey(in),
-					Status:  OutputStatusSuccess,
-					Records: o,
-				}
+		// Combinerが設定されている場合は、出力をバッファに溜めておき、集約してから後段に渡す
+		// 失敗した処理単位もGroupCommitの保留を解除するため、レコードがなくてもバッファに追加する
+		var combined []Output
+		if combiner != nil {
+			output.Records, combined = combiner.add(ctx, unit, output.Records)
+		}
 
-				return nil
-			})
+		outputs <- output
+		for _, o := range combined {
+			if cerr := p.combineError(o); err == nil {
+				err = cerr
+			}
+			rt.bypass(o.Unit)
+			outputs <- o
 		}
+		return err
+	}
 
-		if err := eg.Wait(); err != nil {
+	go func() {
+		// 並列数の上限が設定されている場合は、上限と同じ数のワーカーで処理する
+		// ワーカーは実行枠が空いた時点で次のレコードを読み込むため、優先度付きキューから読み込む場合も
+		// 実行を開始する時点で最も優先度の高いレコードが取り出される
+		err := runWorkers(ctx, rt.inputs(inputs), p.maxParallel, run)
+
+		// 全ての処理が完了したら、バッファに残っている出力を集約して後段に渡す
+		if combiner != nil {
+			for _, o := range combiner.flush(ctx) {
+				if cerr := p.combineError(o); err == nil {
+					err = cerr
+				}
+				rt.bypass(o.Unit)
+				outputs <- o
+			}
+		}
+
+		if err != nil {
 			abort <- err
 		}
 		close(outputs)
@@ -100,3 +125,62 @@ func (p *mapProcessor) Process(ctx context.Context, inputs <-chan Record, abort
 
 	return outputs
 }
+
+// abortIfAnyErrorがtrueの場合のみ、集約に失敗したら全体を止めるためのエラーを返す
+func (p *mapProcessor) combineError(o Output) error {
+	if p.abortIfAnyError && o.Status.IsFailure() {
+		return &AbortError{Stage: p.name, Unit: o.Unit, Err: o.Err}
+	}
+	return nil
+}
+
+// unitにはRecordKey(in)を渡す
+func (p *mapProcessor) mapRecord(ctx context.Context, unit string, in Record) (output Output, err error) {
+	ctx, cancel := stageRuntimeFrom(ctx).unitContext(ctx)
+	defer cancel()
+
+	defer func() {
+		if err != nil {
+			output = Output{
+				Unit:   unit,
+				Status: errorStatus(ctx, err),
+				Err:    err,
+			}
+			if output.Status == OutputStatusFiltered {
+				output.Err = nil
+			}
+			// abortIfAnyErrorがtrueの場合のみ、処理が失敗したらerrを返して全体を止める
+			if p.abortIfAnyError && output.Status.IsFailure() {
+				err = &AbortError{Stage: p.name, Unit: output.Unit, Err: err}
+			} else {
+				err = nil
+			}
+		}
+	}()
+
+	// 開始時点ですでにcontextが終了している場合は、実行せずにスキップする
+	if ctx.Err() != nil {
+		return Output{
+			Unit:   unit,
+			Status: OutputStatusSkipped,
+			Err:    ctx.Err(),
+		}, nil
+	}
+
+	// Mapperには元のレコードを渡し、メタデータはcontextから取得できるようにする
+	md, ok := MetadataOf(in)
+	if ok {
+		ctx = withMetadata(ctx, md)
+	}
+
+	o, err := p.mapper.Map(ctx, Unwrap(in))
+	if err != nil {
+		return Output{}, err
+	}
+
+	return Output{
+		Unit:    unit,
+		Status:  OutputStatusSuccess,
+		Records: propagateMetadata(p.name, o, md, ok),
+	}, nil
+}
@@ -77,15 +77,15 @@ func Test_mapProcessor_Process(t *testing.T) {
 						GroupCommit(GroupString("group1_empty")),
 					},
 				},
-				// 順番に処理されるので、timeout以降のものは全てtimeoutとして処理される
+				// 順番に処理されるので、timeout以降のものは実行されずにスキップされる
 				{
 					Unit:   "timeout/id2",
-					Status: OutputStatusError,
+					Status: OutputStatusTimeout,
 					Err:    context.DeadlineExceeded,
 				},
 				{
 					Unit:   "error/id3",
-					Status: OutputStatusError,
+					Status: OutputStatusSkipped,
 					Err:    context.DeadlineExceeded,
 				},
 			},
@@ -106,6 +106,34 @@ func Test_mapProcessor_Process(t *testing.T) {
 			},
 			wantErr: errTestMapper,
 		},
+		{
+			name:   "filtered and cancelled",
+			mapper: newMapProcessor("test", &testMapper{}),
+			args: args{
+				ctx: func() context.Context {
+					ctx, cancel := context.WithCancel(context.Background())
+					// 先に実行されるテストケースの実行時間を考慮して、余裕を持ってキャンセルする
+					time.AfterFunc(300*time.Millisecond, cancel)
+					t.Cleanup(cancel)
+					return ctx
+				}(),
+				inputs: []Record{
+					testRecord{"filtered", "id1"},
+					testRecord{"timeout", "id2"},
+				},
+			},
+			want: []Output{
+				{
+					Unit:   "filtered/id1",
+					Status: OutputStatusFiltered,
+				},
+				{
+					Unit:   "timeout/id2",
+					Status: OutputStatusCancelled,
+					Err:    context.Canceled,
+				},
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -126,7 +154,7 @@ func Test_mapProcessor_Process(t *testing.T) {
 
 			close(abort)
 			if tt.wantErr != nil {
-				assert.ErrorIs(t, tt.wantErr, <-abort)
+				assert.ErrorIs(t, <-abort, tt.wantErr)
 				return
 			}
 
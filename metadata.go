@@ -0,0 +1,151 @@
+package pipeline
+
+import (
+	"context"
+	"time"
+)
+
+// レコードに付与するメタデータ
+// Mapper / Reducerの出力には、入力のレコードのメタデータが自動的に引き継がれる
+type Metadata struct {
+	CreatedAt   time.Time         // 最初にメタデータを付与した時刻
+	SourceStage string            // レコードを出力したステージ
+	TraceID     string            // レコードを追跡するためのID
+	Values      map[string]string // 任意のキーと値
+}
+
+// keyとvalueを追加したメタデータを返す
+// 他のレコードとValuesを共有しているため、Valuesは直接変更せずにこのメソッドを利用すること
+func (m Metadata) WithValue(key, value string) Metadata {
+	values := make(map[string]string, len(m.Values)+1)
+	for k, v := range m.Values {
+		values[k] = v
+	}
+	values[key] = value
+	m.Values = values
+	return m
+}
+
+// メタデータを付与したレコード
+type envelope struct {
+	Record
+	metadata Metadata
+}
+
+// レコードにメタデータを付与する
+// CreatedAtが空の場合は現在時刻を設定する。すでにメタデータが付与されている場合は置き換える
+// Mapper / Reducerには元のレコードが渡されるため、メタデータはMetadataFromContextで取得する
+func WithMetadata(r Record, md Metadata) Record {
+	if _, ok := r.(groupCommit); ok {
+		return r
+	}
+	if md.CreatedAt.IsZero() {
+		md.CreatedAt = time.Now()
+	}
+	return envelope{Record: Unwrap(r), metadata: md}
+}
+
+// レコードに付与されたメタデータを返す
+func MetadataOf(r Record) (Metadata, bool) {
+	e, ok := r.(envelope)
+	return e.metadata, ok
+}
+
+// メタデータを取り除いた元のレコードを返す
+// メタデータを付与したレコードをExecuteの出力から取り出す場合などに利用する
+func Unwrap(r Record) Record {
+	if e, ok := r.(envelope); ok {
+		return e.Record
+	}
+	return r
+}
+
+type metadataContextKey struct{}
+
+// Mapper / Reducerの入力のレコードに付与されたメタデータを返す
+// Reducerの場合は、グループの全てのレコードのメタデータをまとめたものを返す
+func MetadataFromContext(ctx context.Context) (Metadata, bool) {
+	md, ok := ctx.Value(metadataContextKey{}).(Metadata)
+	return md, ok
+}
+
+func withMetadata(ctx context.Context, md Metadata) context.Context {
+	return context.WithValue(ctx, metadataContextKey{}, md)
+}
+
+// レコードからメタデータを取り除き、全てのレコードのメタデータをまとめて返す
+// CreatedAtは最も古いもの、TraceIDは最初に見つかったものを利用し、Valuesは先に見つかった値を優先して統合する
+func unwrapRecords(records []Record) ([]Record, Metadata, bool) {
+	var merged Metadata
+	found := false
+
+	unwrapped := make([]Record, len(records))
+	for i, r := range records {
+		unwrapped[i] = Unwrap(r)
+
+		md, ok := MetadataOf(r)
+		if !ok {
+			continue
+		}
+		if !found {
+			merged = md
+			found = true
+			continue
+		}
+
+		if merged.CreatedAt.After(md.CreatedAt) {
+			merged.CreatedAt = md.CreatedAt
+		}
+		if merged.TraceID == "" {
+			merged.TraceID = md.TraceID
+		}
+		for k, v := range md.Values {
+			if _, ok := merged.Values[k]; !ok {
+				merged = merged.WithValue(k, v)
+			}
+		}
+	}
+
+	return unwrapped, merged, found
+}
+
+// ステージの出力のレコードに、入力のレコードのメタデータを引き継ぐ
+// Mapper / Reducerがメタデータを付与したレコードは、そのメタデータを優先し、空の項目のみ引き継ぐ
+// Mapper / Reducerが返したスライスは変更せず、メタデータを付与する場合は新しいスライスを返す
+func propagateMetadata(stage string, records []Record, md Metadata, inherit bool) []Record {
+	propagated := records
+	copied := false
+	for i, r := range records {
+		if _, ok := r.(groupCommit); ok {
+			continue
+		}
+
+		out, ok := MetadataOf(r)
+		if !ok && !inherit {
+			continue
+		}
+		if !ok {
+			out = md
+		} else if inherit {
+			if out.CreatedAt.IsZero() {
+				out.CreatedAt = md.CreatedAt
+			}
+			if out.TraceID == "" {
+				out.TraceID = md.TraceID
+			}
+			for k, v := range md.Values {
+				if _, ok := out.Values[k]; !ok {
+					out = out.WithValue(k, v)
+				}
+			}
+		}
+		out.SourceStage = stage
+
+		if !copied {
+			propagated = append([]Record{}, records...)
+			copied = true
+		}
+		propagated[i] = WithMetadata(r, out)
+	}
+	return propagated
+}
@@ -0,0 +1,109 @@
+package pipeline
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetadataPropagation(t *testing.T) {
+	createdAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		records []Record
+		want    []Record
+		wantMD  []Metadata
+		wantCtx []string // MapperがMetadataFromContextで取得したTraceID
+	}{
+		{
+			name: "propagate",
+			records: []Record{
+				WithMetadata(testRecord{"group1", "1"}, Metadata{CreatedAt: createdAt, TraceID: "trace1", Values: map[string]string{"key": "value"}}),
+				testRecord{"group1", "2"},
+			},
+			want: []Record{testRecord{"group1", "2"}},
+			// ASSERT: Reducerの出力に、グループのレコードのメタデータが引き継がれる
+			wantMD:  []Metadata{{CreatedAt: createdAt, SourceStage: "Count", TraceID: "trace1", Values: map[string]string{"key": "value"}}},
+			wantCtx: []string{"trace1"},
+		},
+		{
+			name: "merge",
+			records: []Record{
+				WithMetadata(testRecord{"group1", "1"}, Metadata{CreatedAt: createdAt.Add(time.Hour), Values: map[string]string{"a": "1"}}),
+				WithMetadata(testRecord{"group1", "2"}, Metadata{CreatedAt: createdAt, TraceID: "trace2", Values: map[string]string{"a": "2", "b": "2"}}),
+			},
+			want: []Record{testRecord{"group1", "2"}},
+			// ASSERT: CreatedAtは最も古いもの、TraceIDは最初に見つかったもの、Valuesは先に見つかった値が優先される
+			wantMD:  []Metadata{{CreatedAt: createdAt, SourceStage: "Count", TraceID: "trace2", Values: map[string]string{"a": "1", "b": "2"}}},
+			wantCtx: []string{"", "trace2"},
+		},
+		{
+			name: "no metadata",
+			records: []Record{
+				testRecord{"group1", "1"},
+			},
+			// ASSERT: メタデータが付与されていないレコードはそのまま出力される
+			want:   []Record{testRecord{"group1", "1"}},
+			wantMD: []Metadata{{}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch := make(chan string, len(tt.records))
+			outputs, _, err := New(
+				MapStage("Generator", &testSliceGenerator{records: tt.records}),
+				MapFuncStage("Map", func(ctx context.Context, input Record) ([]Record, error) {
+					// ASSERT: Mapperにはメタデータを取り除いた元のレコードが渡される
+					assert.IsType(t, testRecord{}, input)
+					if md, ok := MetadataFromContext(ctx); ok {
+						ch <- md.TraceID
+					}
+					return []Record{input}, nil
+				}, StageMaxParallel(1)),
+				ReduceFuncStage("Count", testCountReducer),
+			).Execute(context.Background())
+			close(ch)
+
+			assert.NoError(t, err)
+			assert.Len(t, outputs, len(tt.want))
+			for i, o := range outputs {
+				md, _ := MetadataOf(o)
+				assert.Equal(t, tt.want[i], Unwrap(o))
+				assert.Equal(t, tt.wantMD[i], md)
+			}
+
+			traceIDs := []string{}
+			for id := range ch {
+				traceIDs = append(traceIDs, id)
+			}
+			assert.ElementsMatch(t, tt.wantCtx, traceIDs)
+		})
+	}
+}
+
+func TestWithMetadata(t *testing.T) {
+	r := WithMetadata(testRecord{"group1", "1"}, Metadata{TraceID: "trace1"})
+	md, ok := MetadataOf(r)
+	assert.True(t, ok)
+	assert.False(t, md.CreatedAt.IsZero())
+	assert.Equal(t, "group1/1", RecordKey(r))
+
+	// ASSERT: すでにメタデータが付与されている場合は置き換えられる
+	r = WithMetadata(r, Metadata{TraceID: "trace2"})
+	md, _ = MetadataOf(r)
+	assert.Equal(t, "trace2", md.TraceID)
+	assert.Equal(t, testRecord{"group1", "1"}, Unwrap(r))
+
+	// ASSERT: GroupCommitにはメタデータを付与しない
+	_, ok = MetadataOf(WithMetadata(GroupCommit(GroupString("group1")), Metadata{}))
+	assert.False(t, ok)
+
+	// ASSERT: WithValueは元のValuesを変更しない
+	base := Metadata{Values: map[string]string{"a": "1"}}
+	added := base.WithValue("b", "2")
+	assert.Equal(t, map[string]string{"a": "1"}, base.Values)
+	assert.Equal(t, map[string]string{"a": "1", "b": "2"}, added.Values)
+}
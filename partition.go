@@ -0,0 +1,53 @@
+package pipeline
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+)
+
+// レコードを振り分けるグループを決める関数
+type Partitioner func(r Record) Group
+
+// Partitionerで振り分けられたグループ
+type Partition int
+
+func (p Partition) String() string {
+	return fmt.Sprintf("partition-%d", int(p))
+}
+
+// Reducerがレコードをグループに振り分ける際に、Record.Group()の代わりにpartitionerを利用する
+// 1つのグループに大量のレコードが集まる場合に、複数のグループに分散させるために利用する
+// 元のグループに対するGroupCommitは無視され、全てのレコードを受け取ってから処理を開始する
+// Mapperに対しては何もしない
+func StagePartitioner(partitioner Partitioner) PipelineStageOption {
+	return func(s *PipelineStage) {
+		if p, ok := s.processor.(*reduceProcessor); ok {
+			p.groupBy = partitioner
+		}
+	}
+}
+
+// keyのハッシュ値によって、レコードをn個のPartitionに振り分ける
+// keyがnilの場合はRecord.Identifier()を利用する
+func HashPartitioner(n int, key func(r Record) string) Partitioner {
+	if key == nil {
+		key = func(r Record) string { return r.Identifier() }
+	}
+	return func(r Record) Group {
+		h := fnv.New32a()
+		h.Write([]byte(key(r)))
+		return Partition(h.Sum32() % uint32(max(n, 1)))
+	}
+}
+
+// keyの値の範囲によって、レコードをlen(bounds)+1個のPartitionに振り分ける
+// boundsは昇順に並べること。bounds[i-1] <= key < bounds[i] のレコードはPartition(i)に振り分けられる
+func RangePartitioner(key func(r Record) string, bounds ...string) Partitioner {
+	return func(r Record) Group {
+		k := key(r)
+		return Partition(sort.Search(len(bounds), func(i int) bool {
+			return bounds[i] > k
+		}))
+	}
+}
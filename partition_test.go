@@ -0,0 +1,86 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRangePartitioner(t *testing.T) {
+	partitioner := RangePartitioner(func(r Record) string { return r.Identifier() }, "c", "m")
+
+	tests := []struct {
+		name string
+		id   string
+		want Group
+	}{
+		{name: "below first bound", id: "a", want: Partition(0)},
+		{name: "equal to bound", id: "c", want: Partition(1)},
+		{name: "between bounds", id: "k", want: Partition(1)},
+		{name: "above last bound", id: "z", want: Partition(2)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, partitioner(testRecord{"group", tt.id}))
+		})
+	}
+}
+
+func TestStagePartitioner(t *testing.T) {
+	records := []Record{}
+	for i := range 100 {
+		records = append(records, testRecord{"group", fmt.Sprint(i)})
+	}
+	records = append(records, GroupCommit(GroupString("group")))
+
+	tests := []struct {
+		name        string
+		partitioner Partitioner
+		wantGroups  int
+	}{
+		{
+			name:       "no partitioner",
+			wantGroups: 1,
+		},
+		{
+			name:        "hash",
+			partitioner: HashPartitioner(4, nil),
+			wantGroups:  4,
+		},
+		{
+			name: "custom",
+			partitioner: func(r Record) Group {
+				return GroupString(fmt.Sprint(len(r.Identifier())))
+			},
+			wantGroups: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := []PipelineStageOption{}
+			if tt.partitioner != nil {
+				opts = append(opts, StagePartitioner(tt.partitioner))
+			}
+
+			outputs, stages, err := New(
+				MapStage("Generator", &testSliceGenerator{records: records}),
+				ReduceFuncStage("Count", func(ctx context.Context, group Group, inputs []Record) ([]Record, error) {
+					return []Record{testRecord{group.String(), fmt.Sprint(len(inputs))}}, nil
+				}, opts...),
+			).Execute(context.Background())
+			assert.NoError(t, err)
+
+			assert.Len(t, stages[1].Outputs, tt.wantGroups)
+			total := 0
+			for _, o := range outputs {
+				var n int
+				fmt.Sscan(o.Identifier(), &n)
+				total += n
+			}
+			// ASSERT: 全てのレコードがいずれかのグループに振り分けられる
+			assert.Equal(t, 100, total)
+		})
+	}
+}
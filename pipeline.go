@@ -2,20 +2,199 @@ package pipeline
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"sync"
 )
 
+var ErrStageNotFound = errors.New("stage not found")
+
+// StageAbortIfAnyErrorを設定したステージでエラーが発生し、パイプラインが中止されたことを表すエラー
+type AbortError struct {
+	Stage string // 中止の原因となったステージ
+	Unit  string // 中止の原因となった処理単位
+	Err   error
+}
+
+func (e *AbortError) Error() string {
+	return fmt.Sprintf("pipeline aborted by stage %s (unit %s): %v", e.Stage, e.Unit, e.Err)
+}
+
+func (e *AbortError) Unwrap() error {
+	return e.Err
+}
+
+// パイプラインの実行結果
+// パイプラインが中止された場合も、中止までに出力されたレコードとステージの実行結果を保持する
+type Result struct {
+	Outputs []Record
+	Stages  []StageExecution
+	Err     error
+}
+
 type Pipeline struct {
-	stages []*PipelineStage
+	stages        []*PipelineStage
+	resourcePools map[string]ResourcePool
 }
 
 func New(stages ...*PipelineStage) *Pipeline {
 	return &Pipeline{
-		stages: stages,
+		stages:        stages,
+		resourcePools: map[string]ResourcePool{},
 	}
 }
 
+// ステージが利用するリソースプールを登録する
+// 同じ名前のリソースプールが既に登録されている場合は上書きする
+func (p *Pipeline) WithResourcePools(pools ...ResourcePool) *Pipeline {
+	for _, pool := range pools {
+		p.resourcePools[pool.Name()] = pool
+	}
+	return p
+}
+
 func (p *Pipeline) Execute(ctx context.Context) (outputs []Record, stages []StageExecution, abortErr error) {
+	return p.Start(ctx).Wait()
+}
+
+// パイプラインの実行をバックグラウンドで開始し、実行中のパイプラインを表すハンドルを返す
+func (p *Pipeline) Start(ctx context.Context) *Run {
+	ctx, cancel := context.WithCancel(ctx)
+
+	r := &Run{
+		done:    make(chan struct{}),
+		cancel:  cancel,
+		control: newRunControl(),
+	}
+	var setupErr error
+	for _, stage := range p.stages {
+		if stage.err != nil {
+			setupErr = errors.Join(setupErr, fmt.Errorf("stage %s: %w", stage.processor.Name(), stage.err))
+		}
+		pools, err := resolveResourcePools(stage.resourcePools, p.resourcePools)
+		if err != nil {
+			setupErr = errors.Join(setupErr, fmt.Errorf("stage %s: %w", stage.processor.Name(), err))
+		}
+		r.stages = append(r.stages, newStageRuntime(stage, r.control, pools))
+	}
+
+	go func() {
+		defer close(r.done)
+		defer cancel()
+		if setupErr != nil {
+			r.abortErr = setupErr
+			return
+		}
+		r.outputs, r.executions, r.abortErr = r.execute(ctx, p.stages)
+	}()
+
+	return r
+}
+
+// Mapper / Reducerから呼び出すと、前段の全てのステージの処理をキャンセルする
+// 必要な件数のレコードが揃った場合など、後段でそれ以上のレコードが不要になった場合に利用する
+// 前段の処理単位はキャンセルもしくはスキップとして扱われ、失敗には含まれない
+// パイプラインの外で呼び出した場合は何もしない
+func StopUpstream(ctx context.Context) {
+	stageRuntimeFrom(ctx).stopUpstream()
+}
+
+// 実行中のパイプライン
+type Run struct {
+	stages  []*stageRuntime
+	done    chan struct{}
+	cancel  context.CancelFunc
+	control *runControl
+
+	outputs    []Record
+	executions []StageExecution
+	abortErr   error
+}
+
+// パイプラインの完了を待ち、Executeと同じ値を返す
+func (r *Run) Wait() (outputs []Record, stages []StageExecution, abortErr error) {
+	<-r.done
+
+	if r.abortErr != nil {
+		return nil, nil, r.abortErr
+	}
+
+	return r.outputs, r.executions, nil
+}
+
+// パイプラインの完了を待ち、実行結果を返す
+// Waitと異なり、パイプラインが中止された場合も中止までの実行結果を返す
+func (r *Run) Result() Result {
+	<-r.done
+
+	return Result{
+		Outputs: r.outputs,
+		Stages:  r.executions,
+		Err:     r.abortErr,
+	}
+}
+
+// パイプラインが完了するとcloseされるchannelを返す
+func (r *Run) Done() <-chan struct{} {
+	return r.done
+}
+
+// パイプラインの実行をキャンセルする
+// 実行中の処理単位にはcontextのキャンセルとして伝わる
+func (r *Run) Cancel() {
+	r.cancel()
+}
+
+// パイプライン全体で、新しい処理単位の実行開始を一時停止する
+// 実行中の処理単位はそのまま継続される
+func (r *Run) Pause() {
+	r.control.gate.pause()
+}
+
+// Pauseで一時停止したパイプラインを再開する
+// PauseStageで個別に一時停止したステージは一時停止されたままになる
+func (r *Run) Resume() {
+	r.control.gate.resume()
+}
+
+// パイプラインを正常に終了させる
+// 新しい処理単位の受け付けを停止し、実行中の処理単位の完了を待った上で、
+// Reducerはそれまでに受け取ったレコードで処理を行う
+// 完了を待つ場合はWaitを呼び出すこと
+func (r *Run) Drain() {
+	r.control.drain()
+}
+
+// 指定した名前のステージで、新しい処理単位の実行開始を一時停止する
+// 実行中の処理単位はそのまま継続される
+func (r *Run) PauseStage(name string) error {
+	return r.eachStage(name, func(rt *stageRuntime) {
+		rt.gate.pause()
+	})
+}
+
+// PauseStageで一時停止したステージを再開する
+func (r *Run) ResumeStage(name string) error {
+	return r.eachStage(name, func(rt *stageRuntime) {
+		rt.gate.resume()
+	})
+}
+
+func (r *Run) eachStage(name string, fn func(rt *stageRuntime)) error {
+	found := false
+	for _, rt := range r.stages {
+		if rt.name == name {
+			fn(rt)
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("%w: %s", ErrStageNotFound, name)
+	}
+	return nil
+}
+
+func (r *Run) execute(ctx context.Context, pipelineStages []*PipelineStage) (outputs []Record, stages []StageExecution, abortErr error) {
 	originInputs := make(chan Record)
 	go func() {
 		originInputs <- originInput{}
@@ -23,7 +202,7 @@ func (p *Pipeline) Execute(ctx context.Context) (outputs []Record, stages []Stag
 	}()
 
 	stageInputs := []chan Record{originInputs}
-	for range p.stages {
+	for range pipelineStages {
 		stageInputs = append(stageInputs, make(chan Record))
 	}
 
@@ -46,8 +225,22 @@ func (p *Pipeline) Execute(ctx context.Context) (outputs []Record, stages []Stag
 		}
 	}()
 
-	for i, stage := range p.stages {
+	// 後段のステージが前段のステージを止められるよう、ステージごとにキャンセルできるcontextを作成しておく
+	stageCtxs := []context.Context{}
+	for i, rt := range r.stages {
+		stageCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		rt.cancel = cancel
+		rt.upstream = r.stages[:i]
+		stageCtxs = append(stageCtxs, withStageRuntime(stageCtx, rt))
+	}
+
+	for i, stage := range pipelineStages {
+		rt := r.stages[i]
+
 		go func() {
+			ctx := stageCtxs[i]
 			if stage.timeout > 0 {
 				ctxTimeout, cancel := context.WithTimeout(ctx, stage.timeout)
 				defer cancel()
@@ -57,10 +250,18 @@ func (p *Pipeline) Execute(ctx context.Context) (outputs []Record, stages []Stag
 
 			pr := stage.processor
 
+			// 後段が実行中の処理単位の完了を待ってからグループの処理を開始する場合は、それまでGroupCommitを保留する
+			var commits *commitTracker
+			if i+1 < len(pipelineStages) && pipelineStages[i+1].commitWaitInFlight {
+				commits = newCommitTracker(rt)
+			}
+
 			summarizedOutputs := []SummarizedOutput{}
-			for o := range pr.Process(ctx, stageInputs[i], abort) {
+			for o := range pr.Process(ctx, rt.buffer(stageInputs[i]), abort) {
+				started := rt.output(o)
+
 				// 前段のoutputを、次のinputに入れる
-				for _, r := range o.Records {
+				for _, r := range commits.observe(o, started) {
 					stageInputs[i+1] <- r
 				}
 				summarizedOutputs = append(summarizedOutputs, o.Summarized())
@@ -72,14 +273,24 @@ func (p *Pipeline) Execute(ctx context.Context) (outputs []Record, stages []Stag
 				Name:    pr.Name(),
 				Type:    pr.Type(),
 				Outputs: summarizedOutputs,
+
+				CircuitBreakerTransitions: rt.circuitBreakerTransitions(),
+				ConcurrencyLimits:         rt.concurrencyLimits(),
+				MaxQueued:                 rt.maxQueued(),
+				LateRecords:               rt.lateRecords(),
 			})
 
+			for _, r := range commits.flush() {
+				stageInputs[i+1] <- r
+			}
+
+			rt.complete()
 			close(stageInputs[i+1])
 		}()
 	}
 
 	outputs = []Record{}
-	for in := range stageInputs[len(p.stages)] {
+	for in := range stageInputs[len(pipelineStages)] {
 		outputs = append(outputs, in)
 	}
 
@@ -87,9 +298,5 @@ func (p *Pipeline) Execute(ctx context.Context) (outputs []Record, stages []Stag
 	close(abort)
 	abortWg.Wait()
 
-	if abortErr != nil {
-		return nil, nil, abortErr
-	}
-
-	return outputs, stages, nil
+	return outputs, stages, abortErr
 }
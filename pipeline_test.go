@@ -158,12 +158,12 @@ func TestPipeline_Execute(t *testing.T) {
 						},
 						{
 							Unit:   "timeout/id3",
-							Status: OutputStatusError,
+							Status: OutputStatusTimeout,
 							Err:    context.DeadlineExceeded,
 						},
 						{
 							Unit:   "group4/id4",
-							Status: OutputStatusError,
+							Status: OutputStatusSkipped,
 							Err:    context.DeadlineExceeded,
 						},
 					},
@@ -194,7 +194,7 @@ func TestPipeline_Execute(t *testing.T) {
 					Outputs: []SummarizedOutput{
 						{
 							Unit:   "group1_mapped_mapped",
-							Status: OutputStatusError,
+							Status: OutputStatusSkipped,
 							Err:    context.DeadlineExceeded,
 						},
 						{
@@ -254,12 +254,12 @@ func TestPipeline_Execute(t *testing.T) {
 						},
 						{
 							Unit:   "timeout/id3",
-							Status: OutputStatusError,
+							Status: OutputStatusTimeout,
 							Err:    context.DeadlineExceeded,
 						},
 						{
 							Unit:   "group4/id4",
-							Status: OutputStatusError,
+							Status: OutputStatusSkipped,
 							Err:    context.DeadlineExceeded,
 						},
 					},
@@ -303,6 +303,101 @@ func TestPipeline_Execute(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "timeout (specific unit)",
+			fields: fields{
+				stages: []*PipelineStage{
+					MapStage("Generator", &testGeneratorTimeout{}),
+					MapStage("Map1", &testMapper{}, StageMaxParallel(1), StageUnitTimeout(100*time.Millisecond)),
+					MapStage("Map2", &testMapper{}),
+					ReduceStage("Reduce", &testReducer{}),
+				},
+			},
+			args: args{
+				ctx: context.Background(),
+			},
+			wantOutputs: []Record{
+				testRecord{"group1_mapped_mapped", "4"},
+				testRecord{"group1_mapped_empty", "0"},
+			},
+			wantStages: []StageExecution{
+				{
+					Name: "Generator",
+					Type: ProcessorTypeMap,
+					Outputs: []SummarizedOutput{
+						{
+							Unit:        "*/*",
+							Status:      OutputStatusSuccess,
+							RecordCount: 4,
+							GroupCount:  4,
+						},
+					},
+				},
+				// タイムアウトしたレコードのみがTimeoutになり、後続のレコードは正常に処理される
+				{
+					Name: "Map1",
+					Type: ProcessorTypeMap,
+					Outputs: []SummarizedOutput{
+						{
+							Unit:        "group1/id1",
+							Status:      OutputStatusSuccess,
+							RecordCount: 2,
+							GroupCount:  2,
+						},
+						{
+							Unit:   "error/id2",
+							Status: OutputStatusError,
+							Err:    errTestMapper,
+						},
+						{
+							Unit:   "timeout/id3",
+							Status: OutputStatusTimeout,
+							Err:    context.DeadlineExceeded,
+						},
+						{
+							Unit:   "group4/id4",
+							Status: OutputStatusSuccess,
+						},
+					},
+				},
+				{
+					Name: "Map2",
+					Type: ProcessorTypeMap,
+					Outputs: []SummarizedOutput{
+						{
+							Unit:        "group1_mapped/id1_1",
+							Status:      OutputStatusSuccess,
+							RecordCount: 2,
+							GroupCount:  2,
+						},
+						{
+							Unit:        "group1_mapped/id1_2",
+							Status:      OutputStatusSuccess,
+							RecordCount: 2,
+							GroupCount:  2,
+						},
+					},
+				},
+				{
+					Name: "Reduce",
+					Type: ProcessorTypeReduce,
+					Outputs: []SummarizedOutput{
+						{
+							Unit:        "group1_mapped_mapped",
+							Status:      OutputStatusSuccess,
+							RecordCount: 1,
+							GroupCount:  1,
+						},
+						{
+							Unit:        "group1_mapped_empty",
+							Status:      OutputStatusSuccess,
+							RecordCount: 1,
+							GroupCount:  1,
+						},
+					},
+				},
+			},
+		},
 		{
 			name: "abort",
 			fields: fields{
@@ -323,7 +418,7 @@ func TestPipeline_Execute(t *testing.T) {
 			}
 			outputs, stages, err := p.Execute(tt.args.ctx)
 			if tt.wantErr != nil {
-				assert.ErrorIs(t, tt.wantErr, err)
+				assert.ErrorIs(t, err, tt.wantErr)
 				return
 			}
 
@@ -338,3 +433,156 @@ func TestPipeline_Execute(t *testing.T) {
 		})
 	}
 }
+
+func TestRun_Control(t *testing.T) {
+	tests := []struct {
+		name           string
+		control        func(t *testing.T, r *Run)
+		wantOutputs    []Record
+		wantMap        StageProgress
+		wantMapOutputs []SummarizedOutput
+	}{
+		{
+			name: "pause and resume",
+			control: func(t *testing.T, r *Run) {
+				r.Pause()
+				assert.True(t, r.Progress()[1].Paused)
+			},
+			wantOutputs: []Record{
+				testRecord{"group1", "1"},
+			},
+			wantMap: StageProgress{Name: "Wait", Type: ProcessorTypeMap, Received: 2, Succeeded: 2, Records: 2, Done: true},
+			wantMapOutputs: []SummarizedOutput{
+				{Unit: "group1/id1", Status: OutputStatusSuccess, RecordCount: 1, GroupCount: 1},
+				{Unit: "error/id2", Status: OutputStatusSuccess, RecordCount: 1, GroupCount: 1},
+			},
+		},
+		{
+			name: "drain",
+			control: func(t *testing.T, r *Run) {
+				r.Drain()
+			},
+			// 実行中だったレコードのみ処理され、Reducerはそれまでに受け取ったレコードで処理を行う
+			wantOutputs: []Record{
+				testRecord{"group1", "1"},
+			},
+			wantMap: StageProgress{Name: "Wait", Type: ProcessorTypeMap, Received: 2, Succeeded: 1, Skipped: 1, Records: 1, Done: true},
+			wantMapOutputs: []SummarizedOutput{
+				{Unit: "group1/id1", Status: OutputStatusSuccess, RecordCount: 1, GroupCount: 1},
+				{Unit: "error/id2", Status: OutputStatusSkipped, Err: ErrDrained},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			release := make(chan struct{})
+			r := New(
+				MapStage("Generator", &testGenerator{}),
+				MapStage("Wait", &testWaitMapper{release: release}, StageMaxParallel(1)),
+				ReduceStage("Reduce", &testReducer{}),
+			).Start(context.Background())
+
+			// 1件目のレコードが実行中になるまで待つ
+			assert.Eventually(t, func() bool {
+				return r.Progress()[1].InFlight == 1
+			}, time.Second, 10*time.Millisecond)
+
+			tt.control(t, r)
+			close(release)
+
+			if r.Status().Paused {
+				// 一時停止中は後続のレコードの処理が開始されない
+				assert.Eventually(t, func() bool {
+					p := r.Progress()[1]
+					return p.Succeeded == 1 && p.InFlight == 0
+				}, time.Second, 10*time.Millisecond)
+				assert.Never(t, func() bool {
+					return r.Progress()[1].InFlight > 0
+				}, 100*time.Millisecond, 10*time.Millisecond)
+				r.Resume()
+			}
+
+			outputs, stages, err := r.Wait()
+			assert.NoError(t, err)
+			assert.ElementsMatch(t, tt.wantOutputs, outputs)
+			assert.ElementsMatch(t, tt.wantMapOutputs, stages[1].Outputs)
+			assert.Equal(t, tt.wantMap, r.Progress()[1])
+		})
+	}
+}
+
+func TestRun_Result(t *testing.T) {
+	tests := []struct {
+		name        string
+		stages      []*PipelineStage
+		wantOutputs []Record
+		wantStages  []StageExecution
+		wantErr     *AbortError
+	}{
+		{
+			name: "abort",
+			stages: []*PipelineStage{
+				MapStage("Generator", &testGenerator{}),
+				MapStage("Map1", &testMapper{}, StageMaxParallel(1), StageAbortIfAnyError(true)),
+			},
+			// 中止までに出力されたレコードが返される
+			wantOutputs: []Record{
+				testRecord{"group1_mapped", "id1_1"},
+				testRecord{"group1_mapped", "id1_2"},
+				GroupCommit(GroupString("group1_empty")),
+			},
+			wantStages: []StageExecution{
+				{
+					Name: "Generator",
+					Type: ProcessorTypeMap,
+					Outputs: []SummarizedOutput{
+						{
+							Unit:        "*/*",
+							Status:      OutputStatusSuccess,
+							RecordCount: 2,
+							GroupCount:  2,
+						},
+					},
+				},
+				{
+					Name: "Map1",
+					Type: ProcessorTypeMap,
+					Outputs: []SummarizedOutput{
+						{
+							Unit:        "group1/id1",
+							Status:      OutputStatusSuccess,
+							RecordCount: 2,
+							GroupCount:  2,
+						},
+						{
+							Unit:   "error/id2",
+							Status: OutputStatusError,
+							Err:    errTestMapper,
+						},
+					},
+				},
+			},
+			wantErr: &AbortError{
+				Stage: "Map1",
+				Unit:  "error/id2",
+				Err:   errTestMapper,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := New(tt.stages...).Start(context.Background()).Result()
+
+			assert.Equal(t, tt.wantErr, res.Err)
+			assert.ErrorIs(t, res.Err, tt.wantErr.Err)
+			assert.ElementsMatch(t, tt.wantOutputs, res.Outputs)
+
+			assert.Equal(t, len(tt.wantStages), len(res.Stages))
+			for i, expected := range tt.wantStages {
+				assert.Equal(t, expected.Name, res.Stages[i].Name)
+				assert.Equal(t, expected.Type, res.Stages[i].Type)
+				assert.ElementsMatch(t, expected.Outputs, res.Stages[i].Outputs)
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/semaphore"
+)
+
+var ErrResourcePoolNotFound = errors.New("resource pool not found")
+
+// リソースプールの設定が不正であることを示すエラー
+var ErrInvalidResourcePool = errors.New("invalid resource pool")
+
+// 複数のステージやパイプラインで共有するリソースの利用枠
+// データベースなど、同じ依存先に対するプロセス全体での同時実行数やリクエストレートを制限するために利用する
+// 同じResourcePoolを複数のパイプラインに登録することで、パイプラインをまたいで制限できる
+type ResourcePool interface {
+	Name() string
+	// 利用枠が確保できるまで待機する
+	// 確保できた場合は、利用が終了した際に呼び出す関数を返す
+	Acquire(ctx context.Context) (release func(), err error)
+}
+
+// ステージの処理単位が、実行中に指定した名前のリソースプールの利用枠を1つずつ消費するようにする
+// リソースプールはPipeline.WithResourcePoolsで登録しておくこと
+func StageResourcePools(names ...string) PipelineStageOption {
+	return func(s *PipelineStage) {
+		s.resourcePools = append(s.resourcePools, names...)
+	}
+}
+
+// 同時に利用できる数をcapacityに制限するリソースプール
+// capacityが0以下の場合は、パイプラインの実行開始時にErrInvalidResourcePoolで中止する
+func NewConcurrencyPool(name string, capacity int64) ResourcePool {
+	return &concurrencyPool{
+		name:     name,
+		capacity: capacity,
+		sem:      semaphore.NewWeighted(max(capacity, 0)),
+	}
+}
+
+type concurrencyPool struct {
+	name     string
+	capacity int64
+	sem      *semaphore.Weighted
+}
+
+func (p *concurrencyPool) validate() error {
+	if p.capacity <= 0 {
+		return fmt.Errorf("%w: %s: capacity must be positive", ErrInvalidResourcePool, p.name)
+	}
+	return nil
+}
+
+func (p *concurrencyPool) Name() string {
+	return p.name
+}
+
+func (p *concurrencyPool) Acquire(ctx context.Context) (release func(), err error) {
+	// 利用枠が確保できることはないため、待機せずにエラーを返す
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	if err := p.sem.Acquire(ctx, 1); err != nil {
+		return nil, err
+	}
+	return func() { p.sem.Release(1) }, nil
+}
+
+// 利用開始のレートをinterval当たり1回に制限するリソースプール
+// 利用されていない間はburst回まで利用枠を貯めておける
+func NewRateLimitPool(name string, interval time.Duration, burst int) ResourcePool {
+	burst = max(burst, 1)
+	return &rateLimitPool{
+		name:     name,
+		interval: interval,
+		burst:    float64(burst),
+		tokens:   float64(burst),
+		last:     time.Now(),
+	}
+}
+
+type rateLimitPool struct {
+	name     string
+	interval time.Duration
+	burst    float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func (p *rateLimitPool) Name() string {
+	return p.name
+}
+
+func (p *rateLimitPool) Acquire(ctx context.Context) (release func(), err error) {
+	for {
+		ok, wait := p.reserve()
+		if ok {
+			return func() {}, nil
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// 利用枠を1つ消費する
+// 利用枠が足りない場合は消費せずに、利用枠が貯まるまでの時間を返す
+func (p *rateLimitPool) reserve() (ok bool, wait time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	if p.interval > 0 {
+		p.tokens = min(p.tokens+float64(now.Sub(p.last))/float64(p.interval), p.burst)
+	} else {
+		p.tokens = p.burst
+	}
+	p.last = now
+
+	if p.tokens >= 1 {
+		p.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - p.tokens) * float64(p.interval))
+}
+
+// ステージが利用するリソースプールを、パイプラインに登録されたものから探す
+// 複数のステージが異なる順序で宣言した場合にデッドロックしないよう、名前の順に並べて重複を取り除く
+func resolveResourcePools(names []string, pools map[string]ResourcePool) ([]ResourcePool, error) {
+	names = slices.Clone(names)
+	slices.Sort(names)
+	names = slices.Compact(names)
+
+	resolved := []ResourcePool{}
+	for _, name := range names {
+		pool, ok := pools[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrResourcePoolNotFound, name)
+		}
+		if v, ok := pool.(interface{ validate() error }); ok {
+			if err := v.validate(); err != nil {
+				return nil, err
+			}
+		}
+		resolved = append(resolved, pool)
+	}
+	return resolved, nil
+}
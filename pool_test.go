@@ -0,0 +1,174 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testTrackingMapper struct {
+	tracker *testWeightTracker
+}
+
+func (m *testTrackingMapper) Map(ctx context.Context, input Record) ([]Record, error) {
+	defer m.tracker.track(1)()
+	return []Record{input}, nil
+}
+
+func TestStageResourcePools(t *testing.T) {
+	records := []Record{}
+	for i := range 6 {
+		records = append(records, testRecord{"group", fmt.Sprint(i)})
+	}
+
+	tests := []struct {
+		name      string
+		pipelines int
+		pools     []ResourcePool
+		use       []string
+		wantMax   int64
+		wantErr   error
+	}{
+		{
+			name:      "shared across pipelines",
+			pipelines: 2,
+			pools:     []ResourcePool{NewConcurrencyPool("db", 2)},
+			use:       []string{"db"},
+			wantMax:   2,
+		},
+		{
+			name:      "multiple pools",
+			pipelines: 2,
+			pools:     []ResourcePool{NewConcurrencyPool("db", 3), NewConcurrencyPool("api", 1)},
+			use:       []string{"db", "api"},
+			wantMax:   1,
+		},
+		{
+			name:      "not found",
+			pipelines: 1,
+			pools:     []ResourcePool{NewConcurrencyPool("db", 2)},
+			use:       []string{"cache"},
+			wantErr:   ErrResourcePoolNotFound,
+		},
+		{
+			name:      "invalid capacity",
+			pipelines: 1,
+			pools:     []ResourcePool{NewConcurrencyPool("db", 0)},
+			use:       []string{"db"},
+			wantErr:   ErrInvalidResourcePool,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := &testWeightTracker{}
+
+			wg := sync.WaitGroup{}
+			errs := make([]error, tt.pipelines)
+			for i := range tt.pipelines {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					_, _, errs[i] = New(
+						MapStage("Generator", &testSliceGenerator{records: records}),
+						MapStage("Map", &testTrackingMapper{tracker: tracker}, StageResourcePools(tt.use...)),
+					).WithResourcePools(tt.pools...).Execute(context.Background())
+				}()
+			}
+			wg.Wait()
+
+			for _, err := range errs {
+				if tt.wantErr != nil {
+					assert.ErrorIs(t, err, tt.wantErr)
+				} else {
+					assert.NoError(t, err)
+				}
+			}
+			// ASSERT: パイプラインをまたいで同時実行数が制限される
+			assert.Equal(t, tt.wantMax, tracker.max)
+		})
+	}
+}
+
+func TestStageResourcePools_Order(t *testing.T) {
+	records := []Record{}
+	for i := range 50 {
+		records = append(records, testRecord{"group", fmt.Sprint(i)})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// ASSERT: ステージごとに異なる順序で宣言しても、同じ順序で確保するためデッドロックしない
+	_, stages, err := New(
+		MapStage("Generator", &testSliceGenerator{records: records}),
+		MapStage("Map1", &testIdentityMapper{}, StageResourcePools("db", "api")),
+		MapStage("Map2", &testIdentityMapper{}, StageResourcePools("api", "db", "api")),
+	).WithResourcePools(NewConcurrencyPool("db", 1), NewConcurrencyPool("api", 1)).Execute(ctx)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 50, stages[1].Count(OutputStatusSuccess))
+	assert.Equal(t, 50, stages[2].Count(OutputStatusSuccess))
+}
+
+func TestStageResourcePools_CircuitBreaker(t *testing.T) {
+	db := NewConcurrencyPool("db", 1)
+
+	// サーキットブレーカーがopenになり、次の処理単位が待機し続けるパイプライン
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		New(
+			MapStage("Generator", &testSliceGenerator{records: []Record{
+				testRecord{"group", "fail"},
+				testRecord{"group", "wait"},
+			}}),
+			MapFuncStage("Map", func(ctx context.Context, input Record) ([]Record, error) {
+				return nil, errors.New("failed")
+			}, StageMaxParallel(1), StageResourcePools("db"), StageCircuitBreaker(CircuitBreakerConfig{
+				ConsecutiveFailures: 1,
+				OpenDuration:        10 * time.Second,
+				WaitWhenOpen:        true,
+			})),
+		).WithResourcePools(db).Execute(ctx)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	runCtx, runCancel := context.WithTimeout(context.Background(), time.Second)
+	defer runCancel()
+	_, stages, err := New(
+		MapStage("Generator", &testSliceGenerator{records: []Record{testRecord{"group", "1"}}}),
+		MapStage("Map", &testIdentityMapper{}, StageResourcePools("db")),
+	).WithResourcePools(db).Execute(runCtx)
+
+	// ASSERT: openの間に待機している処理単位は、共有のリソースプールの利用枠を占有しない
+	assert.NoError(t, err)
+	assert.Equal(t, 1, stages[1].Count(OutputStatusSuccess))
+
+	cancel()
+	<-done
+}
+
+func TestNewRateLimitPool(t *testing.T) {
+	pool := NewRateLimitPool("api", 20*time.Millisecond, 2)
+
+	start := time.Now()
+	for range 5 {
+		release, err := pool.Acquire(context.Background())
+		assert.NoError(t, err)
+		release()
+	}
+	// ASSERT: burstの2回を除いた3回分の待機が発生する
+	assert.GreaterOrEqual(t, time.Since(start), 55*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := pool.Acquire(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+}
@@ -0,0 +1,133 @@
+package pipeline
+
+import (
+	"container/heap"
+	"time"
+)
+
+const (
+	defaultPriorityQueueSize = 1000
+	defaultPriorityAging     = time.Second
+)
+
+// レコードの優先度を返す関数。値が大きいほど先に処理される
+type PriorityFunc func(r Record) int
+
+// Mapperが処理するレコードを、到着順ではなく優先度の高い順に取り出すようにする
+// 入力は優先度付きキューにバッファリングされ、実行枠が空いた時点で最も優先度の高いレコードから処理される
+// 並列数の上限に達していない場合はすぐに処理されるため、StageMaxParallelなどと併用すること
+// Reducerに対しては何もしない
+func StagePriority(priority PriorityFunc) PipelineStageOption {
+	return func(s *PipelineStage) {
+		s.priority = priority
+	}
+}
+
+// StagePriorityで利用する優先度付きキューの設定
+// sizeはキューにバッファリングするレコードの上限で、上限に達すると前段からの読み込みを待機する。0の場合は1000件
+// 優先度の低いレコードがいつまでも処理されないことを防ぐため、キューで待機している間はagingごとに優先度を1ずつ上げる。0の場合は1秒
+func StagePriorityQueue(size int, aging time.Duration) PipelineStageOption {
+	return func(s *PipelineStage) {
+		s.priorityQueueSize = size
+		s.priorityAging = aging
+	}
+}
+
+// 入力を優先度付きキューに読み込み、優先度の高い順に出力するchannelを返す
+// GroupCommitはMapperでは利用しないため読み捨てる
+func prioritize(inputs <-chan Record, priority PriorityFunc, size int, aging time.Duration) <-chan Record {
+	if size <= 0 {
+		size = defaultPriorityQueueSize
+	}
+	if aging <= 0 {
+		aging = defaultPriorityAging
+	}
+
+	outputs := make(chan Record)
+
+	go func() {
+		defer close(outputs)
+
+		q := newPriorityQueue(aging)
+		for inputs != nil || q.Len() > 0 {
+			// キューが上限に達している間は前段からの読み込みを止める
+			var recv <-chan Record
+			if inputs != nil && q.Len() < size {
+				recv = inputs
+			}
+			var send chan<- Record
+			var next Record
+			if q.Len() > 0 {
+				send = outputs
+				next = q.items[0].record
+			}
+
+			select {
+			case in, ok := <-recv:
+				if !ok {
+					inputs = nil
+					continue
+				}
+				if _, ok := in.(groupCommit); ok {
+					continue
+				}
+				q.push(in, priority(Unwrap(in)), time.Now())
+			case send <- next:
+				heap.Pop(q)
+			}
+		}
+	}()
+
+	return outputs
+}
+
+type priorityItem struct {
+	record Record
+	// 待機時間による優先度の上昇を含めて比較するための値
+	// 優先度をp、キューに入った時刻をt、現在時刻をnowとすると、実効的な優先度は p + (now - t) / aging となるが、
+	// nowは全てのレコードで共通のため p - t / aging で比較すればよい
+	rank float64
+	seq  int
+}
+
+type priorityQueue struct {
+	aging time.Duration
+	start time.Time
+	items []priorityItem
+	seq   int
+}
+
+func newPriorityQueue(aging time.Duration) *priorityQueue {
+	return &priorityQueue{
+		aging: aging,
+		start: time.Now(),
+	}
+}
+
+func (q *priorityQueue) push(r Record, priority int, at time.Time) {
+	q.seq++
+	heap.Push(q, priorityItem{
+		record: r,
+		rank:   float64(priority) - float64(at.Sub(q.start))/float64(q.aging),
+		seq:    q.seq,
+	})
+}
+
+func (q *priorityQueue) Len() int { return len(q.items) }
+
+func (q *priorityQueue) Less(i, j int) bool {
+	if q.items[i].rank != q.items[j].rank {
+		return q.items[i].rank > q.items[j].rank
+	}
+	return q.items[i].seq < q.items[j].seq
+}
+
+func (q *priorityQueue) Swap(i, j int) { q.items[i], q.items[j] = q.items[j], q.items[i] }
+
+func (q *priorityQueue) Push(x any) { q.items = append(q.items, x.(priorityItem)) }
+
+func (q *priorityQueue) Pop() any {
+	item := q.items[len(q.items)-1]
+	q.items = q.items[:len(q.items)-1]
+	return item
+}
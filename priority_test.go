@@ -0,0 +1,135 @@
+package pipeline
+
+import (
+	"container/heap"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// blockerグループのレコードの処理中に後続のレコードがキューに溜まるよう、処理を遅らせる
+type testBlockerMapper struct{}
+
+func (m *testBlockerMapper) Map(ctx context.Context, input Record) ([]Record, error) {
+	if input.Group().String() == "blocker" {
+		time.Sleep(50 * time.Millisecond)
+	}
+	return []Record{input}, nil
+}
+
+func testPriority(r Record) int {
+	return map[string]int{"blocker": 100, "high": 10, "mid": 5, "low": 1}[r.Group().String()]
+}
+
+func TestStagePriority(t *testing.T) {
+	records := []Record{
+		testRecord{"blocker", "1"},
+		testRecord{"low", "1"},
+		testRecord{"high", "1"},
+		GroupCommit(GroupString("low")),
+		testRecord{"low", "2"},
+		testRecord{"mid", "1"},
+	}
+
+	tests := []struct {
+		name string
+		opts []PipelineStageOption
+		want []Record
+	}{
+		{
+			name: "arrival order",
+			opts: []PipelineStageOption{StageMaxParallel(1)},
+			want: []Record{
+				testRecord{"blocker", "1"},
+				testRecord{"low", "1"},
+				testRecord{"high", "1"},
+				testRecord{"low", "2"},
+				testRecord{"mid", "1"},
+			},
+		},
+		{
+			name: "priority order",
+			opts: []PipelineStageOption{StageMaxParallel(1), StagePriority(testPriority)},
+			want: []Record{
+				testRecord{"blocker", "1"},
+				testRecord{"high", "1"},
+				testRecord{"mid", "1"},
+				testRecord{"low", "1"},
+				testRecord{"low", "2"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outputs, _, err := New(
+				MapStage("Generator", &testSliceGenerator{records: records}),
+				MapStage("Map", &testBlockerMapper{}, tt.opts...),
+			).Execute(context.Background())
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, outputs)
+		})
+	}
+}
+
+func TestPriorityQueue(t *testing.T) {
+	start := time.Now()
+
+	tests := []struct {
+		name  string
+		aging time.Duration
+		push  []struct {
+			record   Record
+			priority int
+			at       time.Duration
+		}
+		want []Record
+	}{
+		{
+			name:  "same priority is fifo",
+			aging: time.Hour,
+			push: []struct {
+				record   Record
+				priority int
+				at       time.Duration
+			}{
+				{testRecord{"a", "1"}, 1, 0},
+				{testRecord{"a", "2"}, 1, 0},
+				{testRecord{"b", "1"}, 2, 0},
+			},
+			want: []Record{testRecord{"b", "1"}, testRecord{"a", "1"}, testRecord{"a", "2"}},
+		},
+		{
+			name:  "aging",
+			aging: 10 * time.Millisecond,
+			push: []struct {
+				record   Record
+				priority int
+				at       time.Duration
+			}{
+				{testRecord{"low", "1"}, 0, 0},
+				// ASSERT: 50ms待機したlowの実効的な優先度は5になり、後から入った優先度3のレコードより先に取り出される
+				{testRecord{"high", "1"}, 3, 50 * time.Millisecond},
+				{testRecord{"high", "2"}, 6, 50 * time.Millisecond},
+			},
+			want: []Record{testRecord{"high", "2"}, testRecord{"low", "1"}, testRecord{"high", "1"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newPriorityQueue(tt.aging)
+			q.start = start
+			for _, p := range tt.push {
+				q.push(p.record, p.priority, start.Add(p.at))
+			}
+
+			got := []Record{}
+			for q.Len() > 0 {
+				got = append(got, heap.Pop(q).(priorityItem).record)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -1,6 +1,10 @@
 package pipeline
 
-import "context"
+import (
+	"context"
+	"errors"
+	"time"
+)
 
 type Processor interface {
 	Name() string
@@ -24,15 +28,34 @@ const (
 type OutputStatus string
 
 const (
-	OutputStatusSuccess OutputStatus = "Success"
-	OutputStatusError   OutputStatus = "Error"
+	OutputStatusSuccess   OutputStatus = "Success"
+	OutputStatusError     OutputStatus = "Error"
+	OutputStatusTimeout   OutputStatus = "Timeout"   // 実行中にタイムアウトした
+	OutputStatusCancelled OutputStatus = "Cancelled" // 実行中にキャンセルされた（abortによる中止を含む）
+	OutputStatusSkipped   OutputStatus = "Skipped"   // 開始時点でcontextが終了していた、もしくはドレイン中だったため実行されなかった
+	OutputStatusFiltered  OutputStatus = "Filtered"  // ErrFilteredが返され、意図的に除外された
 )
 
+// 処理が失敗したことを表すステータスかどうか
+// キャンセルやスキップなど、他の処理単位の失敗やタイムアウトの結果として発生したものは含まない
+func (s OutputStatus) IsFailure() bool {
+	return s == OutputStatusError || s == OutputStatusTimeout
+}
+
+// Mapper / Reducerがレコードを意図的に除外したことを示すエラー
+// このエラーを返した処理単位はエラーとして扱われず、OutputStatusFilteredとして集計される
+var ErrFiltered = errors.New("filtered")
+
+// パイプラインのドレイン中のため、処理単位が実行されなかったことを示すエラー
+var ErrDrained = errors.New("pipeline drained")
+
 type Output struct {
 	Unit    string
 	Status  OutputStatus
 	Records []Record
 	Err     error
+	// Reducerで、処理を開始したグループに遅れて到着し、この処理単位で扱ったレコードの数
+	Late int
 }
 
 type SummarizedOutput struct {
@@ -41,6 +64,7 @@ type SummarizedOutput struct {
 	RecordCount int
 	GroupCount  int
 	Err         error
+	Late        int
 }
 
 func (o Output) Summarized() SummarizedOutput {
@@ -59,5 +83,33 @@ func (o Output) Summarized() SummarizedOutput {
 		RecordCount: recordCount,
 		GroupCount:  len(groups),
 		Err:         o.Err,
+		Late:        o.Late,
+	}
+}
+
+// 処理単位ごとのタイムアウトを設定したcontextを返す
+// タイムアウトを設定しない場合は、レコードごとにcontextを作らないようctxをそのまま返す
+func withUnitTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// 処理単位がエラーを返した際のステータスを返す
+// ctxには処理単位のcontextを渡すこと
+// contextの終了後に返されたエラーでも、contextのエラーでなければ処理単位自体の失敗としてOutputStatusErrorにする
+func errorStatus(ctx context.Context, err error) OutputStatus {
+	switch {
+	case errors.Is(err, ErrFiltered):
+		return OutputStatusFiltered
+	case !errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, context.Canceled):
+		return OutputStatusError
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return OutputStatusTimeout
+	case ctx.Err() == nil && errors.Is(err, context.DeadlineExceeded):
+		return OutputStatusTimeout
+	default:
+		return OutputStatusCancelled
 	}
 }
@@ -1,6 +1,9 @@
 package pipeline
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -38,3 +41,33 @@ func TestOutput_Summarized(t *testing.T) {
 		})
 	}
 }
+
+func TestErrorStatus(t *testing.T) {
+	errBusiness := errors.New("business error")
+
+	timedOut, cancel := context.WithTimeout(context.Background(), 0)
+	t.Cleanup(cancel)
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		err  error
+		want OutputStatus
+	}{
+		{name: "error", ctx: context.Background(), err: errBusiness, want: OutputStatusError},
+		{name: "filtered", ctx: context.Background(), err: fmt.Errorf("wrap: %w", ErrFiltered), want: OutputStatusFiltered},
+		{name: "timeout", ctx: timedOut, err: timedOut.Err(), want: OutputStatusTimeout},
+		{name: "cancelled", ctx: cancelled, err: fmt.Errorf("wrap: %w", context.Canceled), want: OutputStatusCancelled},
+		// ASSERT: contextの終了後に返されたエラーでも、contextのエラーでなければエラーとして扱われる
+		{name: "error after timeout", ctx: timedOut, err: errBusiness, want: OutputStatusError},
+		{name: "error after cancel", ctx: cancelled, err: errBusiness, want: OutputStatusError},
+		{name: "deadline exceeded without context", ctx: context.Background(), err: context.DeadlineExceeded, want: OutputStatusTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, errorStatus(tt.ctx, tt.err))
+		})
+	}
+}
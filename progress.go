@@ -0,0 +1,83 @@
+package pipeline
+
+import (
+	"context"
+	"time"
+)
+
+// SubscribeProgressでintervalが0以下の場合に利用する送信間隔
+const defaultProgressInterval = time.Second
+
+// 実行中のステージの進捗
+// 処理単位はMapperの場合はレコード、Reducerの場合はグループを表す
+type StageProgress struct {
+	Name string        `json:"name"`
+	Type ProcessorType `json:"type"`
+
+	Received  int  `json:"received"`  // 受け取った処理単位の数
+	InFlight  int  `json:"inFlight"`  // 実行中の処理単位の数
+	Succeeded int  `json:"succeeded"` // 成功した処理単位の数
+	Failed    int  `json:"failed"`    // 失敗（エラーもしくはタイムアウト）した処理単位の数
+	Cancelled int  `json:"cancelled"` // 実行中にキャンセルされた処理単位の数
+	Skipped   int  `json:"skipped"`   // 実行されずにスキップされた処理単位の数
+	Filtered  int  `json:"filtered"`  // 意図的に除外された処理単位の数
+	Records   int  `json:"records"`   // 後段に出力したレコードの数
+	Late      int  `json:"late"`      // Reducerで、処理を開始したグループに遅れて到着したレコードの数
+	Done      bool `json:"done"`      // ステージの全ての処理が完了したかどうか
+	Paused    bool `json:"paused"`    // ステージが一時停止されているかどうか
+
+	CircuitBreaker   CircuitBreakerState `json:"circuitBreaker,omitempty"`   // サーキットブレーカーの状態
+	ConcurrencyLimit int                 `json:"concurrencyLimit,omitempty"` // StageAdaptiveParallelで調整された現在の並列数
+	Queued           int                 `json:"queued,omitempty"`           // 入力のバッファに溜まっているレコードの数
+	QueuedBytes      int64               `json:"queuedBytes,omitempty"`      // 入力のバッファに溜まっているレコードのサイズの合計
+}
+
+// 実行中のパイプラインの各ステージの進捗を、定義したステージ順に返す
+func (r *Run) Progress() []StageProgress {
+	progress := make([]StageProgress, 0, len(r.stages))
+	for _, rt := range r.stages {
+		progress = append(progress, rt.snapshot())
+	}
+	return progress
+}
+
+// interval間隔で進捗を送信するchannelを返す
+// パイプラインが完了すると最終的な進捗を送信した後にcloseされる
+// ctxがキャンセルされた場合はその時点でcloseされる
+// intervalが0以下の場合は1秒間隔で送信する
+func (r *Run) SubscribeProgress(ctx context.Context, interval time.Duration) <-chan []StageProgress {
+	ch := make(chan []StageProgress)
+	if interval <= 0 {
+		interval = defaultProgressInterval
+	}
+
+	go func() {
+		defer close(ch)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-r.done:
+				select {
+				case ch <- r.Progress():
+				case <-ctx.Done():
+				}
+				return
+			case <-ticker.C:
+				select {
+				case ch <- r.Progress():
+				case <-ctx.Done():
+					return
+				case <-r.done:
+					// 完了時の進捗は次のループで送信する
+				}
+			}
+		}
+	}()
+
+	return ch
+}
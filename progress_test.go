@@ -0,0 +1,61 @@
+package pipeline
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRun_Progress(t *testing.T) {
+	tests := []struct {
+		name     string
+		stages   []*PipelineStage
+		interval time.Duration
+		want     []StageProgress
+	}{
+		{
+			name:     "happy path",
+			interval: 10 * time.Millisecond,
+			stages: []*PipelineStage{
+				MapStage("Generator", &testGenerator{}),
+				MapStage("Map1", &testMapper{}),
+				MapStage("Map2", &testMapper{}),
+				ReduceStage("Reduce", &testReducer{}),
+			},
+			want: []StageProgress{
+				{Name: "Generator", Type: ProcessorTypeMap, Received: 1, Succeeded: 1, Records: 2, Done: true},
+				{Name: "Map1", Type: ProcessorTypeMap, Received: 2, Succeeded: 1, Failed: 1, Records: 2, Done: true},
+				{Name: "Map2", Type: ProcessorTypeMap, Received: 2, Succeeded: 2, Records: 4, Done: true},
+				{Name: "Reduce", Type: ProcessorTypeReduce, Received: 2, Succeeded: 2, Records: 2, Done: true},
+			},
+		},
+		{
+			name: "zero interval",
+			stages: []*PipelineStage{
+				MapStage("Generator", &testGenerator{}),
+			},
+			// ASSERT: intervalが0以下でもpanicせず、完了時の進捗が送信される
+			interval: 0,
+			want: []StageProgress{
+				{Name: "Generator", Type: ProcessorTypeMap, Received: 1, Succeeded: 1, Records: 2, Done: true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := New(tt.stages...).Start(context.Background())
+
+			var last []StageProgress
+			for p := range r.SubscribeProgress(context.Background(), tt.interval) {
+				last = p
+			}
+
+			_, _, err := r.Wait()
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, last)
+			assert.Equal(t, tt.want, r.Progress())
+		})
+	}
+}
@@ -40,11 +40,19 @@ func (g GroupString) String() string {
 // 特定のグループのレコードが全て出力されたことを示すレコード
 // Reducerのグループを確定させるために利用され、レコードとしては無視される
 type groupCommit struct {
-	group Group
+	group    Group
+	expected int // グループのレコードの件数。0の場合は件数によらずすぐに確定させる
 }
 
 func GroupCommit(g Group) groupCommit {
-	return groupCommit{g}
+	return groupCommit{group: g}
+}
+
+// グループのレコードの件数をcountとして宣言するGroupCommit
+// ReducerはGroupCommitを受け取った後も、count件のレコードを受け取るまでグループの処理を開始しない
+// 前段の複数の処理単位が同じグループのレコードを出力する場合など、GroupCommitがレコードより先に届くことがある場合に利用する
+func GroupCommitWithCount(g Group, count int) groupCommit {
+	return groupCommit{group: g, expected: count}
 }
 
 func (g groupCommit) Group() Group {
@@ -57,5 +65,5 @@ func (g groupCommit) Identifier() string {
 
 // Deprecated: 代わりにGroupCommitを利用してください
 func EmptyGroup(g Group) groupCommit {
-	return groupCommit{g}
+	return GroupCommit(g)
 }
@@ -2,6 +2,7 @@ package pipeline
 
 import (
 	"context"
+	"time"
 
 	"golang.org/x/sync/errgroup"
 )
@@ -16,6 +17,12 @@ type reduceProcessor struct {
 	reducer         Reducer
 	maxParallel     int
 	abortIfAnyError bool
+	// レコードをグループに振り分けるための関数。nilの場合はRecord.Group()
+	groupBy Partitioner
+	// trueの場合は、GroupPathの上位の全ての階層のグループでも処理する
+	rollup bool
+	// 処理を開始したグループに遅れて到着したレコードの扱い
+	lateRecords LateRecordConfig
 }
 
 type ReducerOption func(p *reduceProcessor)
@@ -35,6 +42,16 @@ func newReduceProcessor(name string, reducer Reducer, opts ...ReducerOption) *re
 	return p
 }
 
+// 全てのレコードを1つのグループとして処理する
+// 元のグループに対するGroupCommitは無視され、全てのレコードを受け取ってから処理を開始する
+func reduceAll() ReducerOption {
+	return func(p *reduceProcessor) {
+		p.groupBy = func(r Record) Group {
+			return GroupNA
+		}
+	}
+}
+
 func (p *reduceProcessor) SetMaxParallel(max int) {
 	p.maxParallel = max
 }
@@ -60,46 +77,127 @@ func (p *reduceProcessor) Process(ctx context.Context, inputs <-chan Record, abo
 	}
 
 	type group struct {
-		group Group
-		done  bool
+		group     Group
+		done      bool // 処理を開始したかどうか
+		committed bool // GroupCommitを受け取ったかどうか
+		expected  int  // GroupCommitで宣言されたレコードの件数
+		received  int  // 受け取ったレコードの件数
+	}
+
+	rt := stageRuntimeFrom(ctx)
+
+	// グループの処理を開始する
+	// lateがtrueの場合は、遅れて到着したレコードを処理する
+	startGroup := func(group Group, inputs []Record, late bool) {
+		unit := group.String()
+		if late {
+			unit = lateUnit(group)
+		}
+
+		// 重み付きで並列数を制限する場合は、実行枠が空くまで入力の読み込みを待機する
+		slot := rt.acquire(ctx, inputs...)
+
+		eg.Go(func() error {
+			// ドレイン中もそれまでに受け取ったレコードで処理を行うため、drainableはfalseにする
+			running, err := rt.begin(ctx, unit, false)
+			if err != nil {
+				slot.release(0, OutputStatusSkipped)
+				outputs <- Output{
+					Unit:   unit,
+					Status: OutputStatusSkipped,
+					Err:    err,
+				}
+				return nil
+			}
+
+			start := time.Now()
+			output, err := p.reduce(ctx, unit, group, inputs, late)
+			slot.release(time.Since(start), output.Status)
+			// 失敗の許容量を超えた場合もerrを返して全体を止める
+			if budgetErr := running.end(output); err == nil {
+				err = budgetErr
+			}
+			outputs <- output
+			return err
+		})
 	}
 
 	go func() {
 		groups := map[string]*group{}
 		groupedInputs := map[string][]Record{}
-		for in := range inputs {
-			gr := in.Group().String()
+		// 処理を開始したグループに遅れて到着したレコード
+		lateInputs := map[string][]Record{}
 
-			if g, ok := groups[gr]; ok {
-				// すでにコミットされたグループは無視する
-				if g.done {
-					continue
-				}
-			} else {
+		// レコードをグループに追加する
+		add := func(key Group, in Record) {
+			gr := key.String()
+			commit, isCommit := in.(groupCommit)
+
+			g, ok := groups[gr]
+			if !ok {
 				// 新しいグループの場合はグループ一覧に追加する
-				groups[gr] = &group{
-					group: in.Group(),
-					done:  false,
+				g = &group{group: key}
+				groups[gr] = g
+				rt.received()
+			}
+
+			// すでに処理を開始したグループのレコードは、遅れて到着したレコードとして記録する
+			if g.done {
+				if !isCommit {
+					rt.late(1)
+					lateInputs[gr] = append(lateInputs[gr], in)
 				}
+				return
+			}
+
+			if isCommit {
+				g.committed = true
+				g.expected = commit.expected
+			} else {
+				g.received++
+				groupedInputs[gr] = append(groupedInputs[gr], in)
 			}
 
-			// GroupCommitが流れてきた場合、すぐにgroupの処理を開始して、レコードをmapから削除する
+			// GroupCommitを受け取り、期待する件数のレコードが揃ったら、すぐにgroupの処理を開始して、レコードをmapから削除する
 			// こうすることで、必要以上にメモリを使用しないようにする
-			if _, ok := in.(groupCommit); ok {
-				groups[gr].done = true
+			if g.committed && g.received >= g.expected {
+				g.done = true
 				inputs := groupedInputs[gr]
 				delete(groupedInputs, gr)
 
-				eg.Go(func() error {
-					output, err := p.reduce(ctx, in.Group(), inputs)
-					if err != nil {
-						return err
+				startGroup(g.group, inputs, false)
+			}
+		}
+
+		for in := range inputs {
+			if _, ok := in.(groupCommit); ok && p.groupBy != nil {
+				continue
+			}
+
+			key := in.Group()
+			if p.groupBy != nil {
+				key = p.groupBy(Unwrap(in))
+			}
+
+			if !p.rollup {
+				add(key, in)
+				continue
+			}
+
+			// 階層ごとに集計する場合は、レコードを上位の全ての階層のグループにも追加する
+			// GroupCommitの場合は、そのグループと下位の全ての階層のグループをコミットする
+			if _, ok := in.(groupCommit); ok {
+				key := toGroupPath(key)
+				add(key, in)
+				for _, g := range groups {
+					if !g.done && isDescendantGroup(g.group, key) {
+						add(g.group, GroupCommit(g.group))
 					}
-					outputs <- output
-					return nil
-				})
-			} else {
-				groupedInputs[gr] = append(groupedInputs[gr], in)
+				}
+				continue
+			}
+			for _, key := range rollupGroups(key) {
+				add(key, in)
 			}
 		}
 
@@ -115,14 +213,23 @@ func (p *reduceProcessor) Process(ctx context.Context, inputs <-chan Record, abo
 			inputs := groupedInputs[gr]
 			delete(groupedInputs, gr)
 
-			eg.Go(func() error {
-				output, err := p.reduce(ctx, group.group, inputs)
-				if err != nil {
-					return err
+			startGroup(group.group, inputs, false)
+		}
+
+		// 遅れて到着したレコードを、設定に従ってグループごとに処理する
+		for gr, inputs := range lateInputs {
+			rt.received()
+			if p.lateRecords.drop() {
+				output := Output{
+					Unit:   lateUnit(groups[gr].group),
+					Status: OutputStatusFiltered,
+					Late:   len(inputs),
 				}
+				rt.bypass(output.Unit)
 				outputs <- output
-				return nil
-			})
+				continue
+			}
+			startGroup(groups[gr].group, inputs, true)
 		}
 
 		if err := eg.Wait(); err != nil {
@@ -134,33 +241,60 @@ func (p *reduceProcessor) Process(ctx context.Context, inputs <-chan Record, abo
 	return outputs
 }
 
-func (p *reduceProcessor) reduce(ctx context.Context, group Group, inputs []Record) (output Output, err error) {
+func (p *reduceProcessor) reduce(ctx context.Context, unit string, group Group, inputs []Record, late bool) (output Output, err error) {
+	ctx, cancel := stageRuntimeFrom(ctx).unitContext(ctx)
+	defer cancel()
+
 	defer func() {
-		// abortIfAnyErrorがfalseの場合は、errを返す代わりにエラーステータスを持った通常レコードを返す
-		if err != nil && !p.abortIfAnyError {
+		if err != nil {
 			output = Output{
-				Unit:   group.String(),
-				Status: OutputStatusError,
+				Unit:   unit,
+				Status: errorStatus(ctx, err),
 				Err:    err,
 			}
-			err = nil
+			if output.Status == OutputStatusFiltered {
+				output.Err = nil
+			}
+			// abortIfAnyErrorがtrueの場合のみ、処理が失敗したらerrを返して全体を止める
+			if p.abortIfAnyError && output.Status.IsFailure() {
+				err = &AbortError{Stage: p.name, Unit: output.Unit, Err: err}
+			} else {
+				err = nil
+			}
+		}
+		if late {
+			output.Late = len(inputs)
 		}
 	}()
 
-	select {
-	case <-ctx.Done():
-		return Output{}, ctx.Err()
-	default:
+	// 開始時点ですでにcontextが終了している場合は、実行せずにスキップする
+	if ctx.Err() != nil {
+		return Output{
+			Unit:   unit,
+			Status: OutputStatusSkipped,
+			Err:    ctx.Err(),
+		}, nil
 	}
 
-	o, err := p.reducer.Reduce(ctx, group, inputs)
+	// Reducerには元のレコードを渡し、グループのメタデータはcontextから取得できるようにする
+	inputs, md, ok := unwrapRecords(inputs)
+	if ok {
+		ctx = withMetadata(ctx, md)
+	}
+
+	var o []Record
+	if late && p.lateRecords.Policy == LateRecordDeadLetter {
+		err = p.lateRecords.DeadLetter(ctx, group, inputs)
+	} else {
+		o, err = p.reducer.Reduce(ctx, group, inputs)
+	}
 	if err != nil {
 		return Output{}, err
 	}
 
 	return Output{
-		Unit:    group.String(),
+		Unit:    unit,
 		Status:  OutputStatusSuccess,
-		Records: o,
+		Records: propagateMetadata(p.name, o, md, ok),
 	}, nil
 }
@@ -33,7 +33,7 @@ func Test_reducerProcessor_Process(t *testing.T) {
 					testRecord{"error", "id4"},
 					GroupCommit(GroupString("group3")),
 					GroupCommit(GroupString("group3")), // ASSERT: 複数回同じGroupCommitが来ても無視される
-					testRecord{"group1", "id5"},        // ASSERT: GroupCommit後に流れてきたレコードは無視される
+					testRecord{"group1", "id5"},        // ASSERT: GroupCommit後に流れてきたレコードは処理されず、破棄したことが出力される
 				},
 			},
 			want: []Output{
@@ -63,6 +63,11 @@ func Test_reducerProcessor_Process(t *testing.T) {
 						testRecord{"group3", "0"},
 					},
 				},
+				{
+					Unit:   "group1/late",
+					Status: OutputStatusFiltered,
+					Late:   1,
+				},
 			},
 		},
 		{
@@ -80,18 +85,20 @@ func Test_reducerProcessor_Process(t *testing.T) {
 				}(),
 				inputs: []Record{
 					testRecord{"timeout1", "id1"},
+					GroupCommit(GroupString("timeout1")), // ASSERT: timeout1が先に処理される
 					testRecord{"timeout2", "id2"},
 				},
 			},
 			want: []Output{
 				{
 					Unit:   "timeout1",
-					Status: OutputStatusError,
+					Status: OutputStatusTimeout,
 					Err:    context.DeadlineExceeded,
 				},
+				// 並列数が1なので、timeout1以降のものは実行されずにスキップされる
 				{
 					Unit:   "timeout2",
-					Status: OutputStatusError,
+					Status: OutputStatusSkipped,
 					Err:    context.DeadlineExceeded,
 				},
 			},
@@ -133,7 +140,7 @@ func Test_reducerProcessor_Process(t *testing.T) {
 
 			close(abort)
 			if tt.wantErr != nil {
-				assert.ErrorIs(t, tt.wantErr, <-abort)
+				assert.ErrorIs(t, <-abort, tt.wantErr)
 				return
 			}
 
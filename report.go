@@ -0,0 +1,221 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// 集計結果に含めるユニットの例の最大件数
+const maxExampleUnits = 5
+
+// どの分類にも当てはまらないエラーの分類名
+const ErrorClassOther = "other"
+
+// エラーの分類
+// Matchがtrueを返したエラーはNameの分類として集計される
+type ErrorClass struct {
+	Name  string
+	Match func(err error) bool
+}
+
+// errors.Isでtargetと一致するエラーを表す分類
+func ErrorClassIs(name string, target error) ErrorClass {
+	return ErrorClass{
+		Name: name,
+		Match: func(err error) bool {
+			return errors.Is(err, target)
+		},
+	}
+}
+
+// errors.Asで型Tとして取り出せるエラーを表す分類
+func ErrorClassAs[T error](name string) ErrorClass {
+	return ErrorClass{
+		Name: name,
+		Match: func(err error) bool {
+			var target T
+			return errors.As(err, &target)
+		},
+	}
+}
+
+// 分類を指定しなかった場合に利用される分類
+var DefaultErrorClasses = []ErrorClass{
+	ErrorClassIs("timeout", context.DeadlineExceeded),
+	ErrorClassIs("canceled", context.Canceled),
+}
+
+// パイプラインの実行結果のエラーを集計したもの
+type ExecutionReport struct {
+	Stages   []StageReport
+	AbortErr error
+}
+
+// ステージごとのエラーの集計
+type StageReport struct {
+	Name          string
+	Type          ProcessorType
+	UnitCount     int            // 処理単位の数
+	ErrorCount    int            // 失敗（エラーもしくはタイムアウト）した処理単位の数
+	ByClass       []ErrorSummary // エラーの分類ごとの集計
+	ByFingerprint []ErrorSummary // エラーメッセージのフィンガープリントごとの集計
+	errs          []error
+}
+
+// 同じ分類、もしくは同じフィンガープリントを持つエラーの集計
+type ErrorSummary struct {
+	Key     string   // 分類名もしくはフィンガープリント
+	Count   int      // エラーの件数
+	Units   []string // エラーになった処理単位の例
+	Example error    // エラーの例
+}
+
+// ステージの実行結果からエラーを集計する
+// classesを省略した場合はDefaultErrorClassesを利用し、先に指定された分類が優先される
+func NewExecutionReport(stages []StageExecution, abortErr error, classes ...ErrorClass) *ExecutionReport {
+	if len(classes) == 0 {
+		classes = DefaultErrorClasses
+	}
+
+	r := &ExecutionReport{
+		AbortErr: abortErr,
+	}
+
+	for _, stage := range stages {
+		sr := StageReport{
+			Name:      stage.Name,
+			Type:      stage.Type,
+			UnitCount: len(stage.Outputs),
+		}
+
+		byClass := newErrorSummaries()
+		byFingerprint := newErrorSummaries()
+		for _, o := range stage.Outputs {
+			// キャンセルやスキップは他の失敗の結果として発生するため、集計対象に含めない
+			if !o.Status.IsFailure() {
+				continue
+			}
+
+			sr.ErrorCount++
+			sr.errs = append(sr.errs, fmt.Errorf("%s %s: %w", stage.Name, o.Unit, o.Err))
+			byClass.add(classifyError(o.Err, classes), o.Unit, o.Err)
+			byFingerprint.add(ErrorFingerprint(o.Err), o.Unit, o.Err)
+		}
+		sr.ByClass = byClass.list()
+		sr.ByFingerprint = byFingerprint.list()
+
+		r.Stages = append(r.Stages, sr)
+	}
+
+	return r
+}
+
+// パイプラインの完了を待ち、実行結果のエラーを集計する
+// パイプラインが中止された場合も、中止までに完了したステージの実行結果を集計する
+func (r *Run) Report(classes ...ErrorClass) *ExecutionReport {
+	<-r.done
+	return NewExecutionReport(r.executions, r.abortErr, classes...)
+}
+
+// 全ての失敗した処理単位のエラーと中止の原因となったエラーを、errors.Joinでまとめたものを返す
+// 各エラーにはステージ名と処理単位が付与される
+func (r *ExecutionReport) Err() error {
+	errs := []error{}
+	if r.AbortErr != nil {
+		errs = append(errs, r.AbortErr)
+	}
+	for _, s := range r.Stages {
+		errs = append(errs, s.errs...)
+	}
+	return errors.Join(errs...)
+}
+
+// 集計結果を1行ずつの文字列として返す
+// 例: "Scanner: 300 timeout, 1 other"
+func (r *ExecutionReport) String() string {
+	lines := []string{}
+	if r.AbortErr != nil {
+		lines = append(lines, "aborted: "+r.AbortErr.Error())
+	}
+	for _, s := range r.Stages {
+		if s.ErrorCount == 0 {
+			continue
+		}
+		classes := []string{}
+		for _, c := range s.ByClass {
+			classes = append(classes, fmt.Sprintf("%d %s", c.Count, c.Key))
+		}
+		lines = append(lines, fmt.Sprintf("%s: %s", s.Name, strings.Join(classes, ", ")))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func classifyError(err error, classes []ErrorClass) string {
+	for _, c := range classes {
+		if c.Match(err) {
+			return c.Name
+		}
+	}
+	return ErrorClassOther
+}
+
+var fingerprintReplacers = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	{regexp.MustCompile(`"[^"]*"`), `"*"`},
+	{regexp.MustCompile(`[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`), "<uuid>"},
+	{regexp.MustCompile(`0x[0-9a-fA-F]+`), "<hex>"},
+	{regexp.MustCompile(`[0-9]+`), "#"},
+}
+
+// エラーメッセージから可変部分（数値、ID、クォートされた文字列）を取り除いたフィンガープリントを返す
+// 例: "failed to scan instance i-123" -> "failed to scan instance i-#"
+func ErrorFingerprint(err error) string {
+	msg := err.Error()
+	for _, r := range fingerprintReplacers {
+		msg = r.re.ReplaceAllString(msg, r.repl)
+	}
+	return msg
+}
+
+type errorSummaries struct {
+	keys      []string
+	summaries map[string]*ErrorSummary
+}
+
+func newErrorSummaries() *errorSummaries {
+	return &errorSummaries{
+		summaries: map[string]*ErrorSummary{},
+	}
+}
+
+func (s *errorSummaries) add(key string, unit string, err error) {
+	summary, ok := s.summaries[key]
+	if !ok {
+		summary = &ErrorSummary{Key: key, Example: err}
+		s.summaries[key] = summary
+		s.keys = append(s.keys, key)
+	}
+
+	summary.Count++
+	if len(summary.Units) < maxExampleUnits {
+		summary.Units = append(summary.Units, unit)
+	}
+}
+
+// 件数の多い順に返す
+func (s *errorSummaries) list() []ErrorSummary {
+	list := make([]ErrorSummary, 0, len(s.keys))
+	for _, key := range s.keys {
+		list = append(list, *s.summaries[key])
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].Count > list[j].Count
+	})
+	return list
+}
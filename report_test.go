@@ -0,0 +1,150 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testAuthError struct{}
+
+func (e *testAuthError) Error() string { return "auth failed" }
+
+func TestNewExecutionReport(t *testing.T) {
+	errScan1 := fmt.Errorf("failed to scan instance i-123: %w", context.DeadlineExceeded)
+	errScan2 := fmt.Errorf("failed to scan instance i-456: %w", context.DeadlineExceeded)
+	errAuth := fmt.Errorf("list instances: %w", &testAuthError{})
+
+	stages := []StageExecution{
+		{
+			Name: "Lister",
+			Type: ProcessorTypeMap,
+			Outputs: []SummarizedOutput{
+				{Unit: "*/*", Status: OutputStatusSuccess, RecordCount: 2},
+			},
+		},
+		{
+			Name: "Scanner",
+			Type: ProcessorTypeMap,
+			Outputs: []SummarizedOutput{
+				{Unit: "region/i-123", Status: OutputStatusError, Err: errScan1},
+				{Unit: "region/i-456", Status: OutputStatusError, Err: errScan2},
+				{Unit: "region/i-789", Status: OutputStatusError, Err: errAuth},
+				{Unit: "region/i-000", Status: OutputStatusSuccess, RecordCount: 1},
+			},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		abortErr error
+		classes  []ErrorClass
+		want     []StageReport
+		wantStr  string
+		wantErrs int
+	}{
+		{
+			name: "default classes",
+			want: []StageReport{
+				{Name: "Lister", Type: ProcessorTypeMap, UnitCount: 1, ByClass: []ErrorSummary{}, ByFingerprint: []ErrorSummary{}},
+				{
+					Name:       "Scanner",
+					Type:       ProcessorTypeMap,
+					UnitCount:  4,
+					ErrorCount: 3,
+					ByClass: []ErrorSummary{
+						{Key: "timeout", Count: 2, Units: []string{"region/i-123", "region/i-456"}, Example: errScan1},
+						{Key: ErrorClassOther, Count: 1, Units: []string{"region/i-789"}, Example: errAuth},
+					},
+					ByFingerprint: []ErrorSummary{
+						{Key: "failed to scan instance i-#: context deadline exceeded", Count: 2, Units: []string{"region/i-123", "region/i-456"}, Example: errScan1},
+						{Key: "list instances: auth failed", Count: 1, Units: []string{"region/i-789"}, Example: errAuth},
+					},
+				},
+			},
+			wantStr:  "Scanner: 2 timeout, 1 other",
+			wantErrs: 3,
+		},
+		{
+			name:     "custom classes",
+			abortErr: errTestBrokenGenerator,
+			classes: []ErrorClass{
+				ErrorClassAs[*testAuthError]("auth"),
+				ErrorClassIs("timeout", context.DeadlineExceeded),
+			},
+			want: []StageReport{
+				{Name: "Lister", Type: ProcessorTypeMap, UnitCount: 1, ByClass: []ErrorSummary{}, ByFingerprint: []ErrorSummary{}},
+				{
+					Name:       "Scanner",
+					Type:       ProcessorTypeMap,
+					UnitCount:  4,
+					ErrorCount: 3,
+					ByClass: []ErrorSummary{
+						{Key: "timeout", Count: 2, Units: []string{"region/i-123", "region/i-456"}, Example: errScan1},
+						{Key: "auth", Count: 1, Units: []string{"region/i-789"}, Example: errAuth},
+					},
+					ByFingerprint: []ErrorSummary{
+						{Key: "failed to scan instance i-#: context deadline exceeded", Count: 2, Units: []string{"region/i-123", "region/i-456"}, Example: errScan1},
+						{Key: "list instances: auth failed", Count: 1, Units: []string{"region/i-789"}, Example: errAuth},
+					},
+				},
+			},
+			wantStr:  "aborted: test broken generator error\nScanner: 2 timeout, 1 auth",
+			wantErrs: 4,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewExecutionReport(stages, tt.abortErr, tt.classes...)
+
+			assert.Equal(t, len(tt.want), len(r.Stages))
+			for i, expected := range tt.want {
+				actual := r.Stages[i]
+				actual.errs = nil
+				assert.Equal(t, expected, actual)
+			}
+			assert.Equal(t, tt.wantStr, r.String())
+
+			err := r.Err()
+			assert.ErrorIs(t, err, context.DeadlineExceeded)
+			var authErr *testAuthError
+			assert.ErrorAs(t, err, &authErr)
+			if tt.abortErr != nil {
+				assert.ErrorIs(t, err, tt.abortErr)
+			}
+			assert.Len(t, err.(interface{ Unwrap() []error }).Unwrap(), tt.wantErrs)
+		})
+	}
+}
+
+func TestErrorFingerprint(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{
+			name: "digits",
+			err:  errors.New("failed to scan instance i-123 (attempt 2)"),
+			want: "failed to scan instance i-# (attempt #)",
+		},
+		{
+			name: "quoted and uuid",
+			err:  errors.New(`bucket "my-bucket" not found: request 123e4567-e89b-12d3-a456-426614174000`),
+			want: `bucket "*" not found: request <uuid>`,
+		},
+		{
+			name: "hex",
+			err:  errors.New("invalid pointer 0xdeadbeef"),
+			want: "invalid pointer <hex>",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, ErrorFingerprint(tt.err))
+		})
+	}
+}
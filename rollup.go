@@ -0,0 +1,100 @@
+package pipeline
+
+import (
+	"context"
+	"strings"
+)
+
+// 階層を持つグループ。先頭から順に上位の階層を表す
+// 例: GroupPath{"ap-northeast-1", "account1"} はリージョンごと、アカウントごとの2階層のグループ
+type GroupPath []string
+
+// 各階層を"/"で連結した文字列を返す。最上位（全体）のグループは"*"
+// 異なるグループが同じ文字列にならないよう、各階層の"\"と"/"、および"*"のみの階層は"\"でエスケープする
+// 例: GroupPath{"a/b"} は`a\/b`、GroupPath{"*"} は`\*`
+func (g GroupPath) String() string {
+	if len(g) == 0 {
+		return na
+	}
+	escaped := make([]string, len(g))
+	for i, s := range g {
+		escaped[i] = groupPathEscaper.Replace(s)
+		if s == na {
+			escaped[i] = `\` + na
+		}
+	}
+	return strings.Join(escaped, "/")
+}
+
+var groupPathEscaper = strings.NewReplacer(`\`, `\\`, "/", `\/`)
+
+// 1つ上の階層のグループを返す
+func (g GroupPath) Parent() GroupPath {
+	if len(g) == 0 {
+		return g
+	}
+	return g[:len(g)-1]
+}
+
+// GroupPathのレコードを、そのグループに加えて上位の全ての階層のグループでもreducerで処理するステージを組み立てる
+// SQLのROLLUPと同様に、各階層の小計と全体の合計を出力するために利用する
+// 各階層のグループのReduceには、そのグループに含まれる全てのレコードが渡される
+// GroupCommitを受け取った場合は、そのグループと下位の全ての階層のグループの処理を開始する
+// 上位の階層のグループは、GroupCommitを受け取るか、全てのレコードを受け取った時点で処理を開始する
+// GroupPath以外のグループは、1階層のGroupPathとして扱う
+func RollupStage(name string, reducer Reducer, opts ...PipelineStageOption) *PipelineStage {
+	return Stage(newReduceProcessor(name, reducer, reduceRollup()), opts...)
+}
+
+// ReducerFuncを利用してRollupStageを組み立てる
+func RollupFuncStage(name string, fn func(ctx context.Context, group Group, inputs []Record) ([]Record, error), opts ...PipelineStageOption) *PipelineStage {
+	return RollupStage(name, ReducerFunc(fn), opts...)
+}
+
+func reduceRollup() ReducerOption {
+	return func(p *reduceProcessor) {
+		p.rollup = true
+	}
+}
+
+// GroupNAは最上位（全体）のグループとして扱う
+func toGroupPath(g Group) GroupPath {
+	if path, ok := g.(GroupPath); ok {
+		return path
+	}
+	if g.String() == na {
+		return GroupPath{}
+	}
+	return GroupPath{g.String()}
+}
+
+// グループ自身と、上位の全ての階層のグループを下位から順に返す
+// 同じ文字列になるグループは、最初のものだけを返す
+func rollupGroups(g Group) []Group {
+	path := toGroupPath(g)
+	groups := []Group{}
+	seen := map[string]struct{}{}
+	for i := len(path); i >= 0; i-- {
+		key := path[:i].String()
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		groups = append(groups, path[:i])
+	}
+	return groups
+}
+
+// childがparentの下位の階層のグループかどうか
+func isDescendantGroup(child Group, parent Group) bool {
+	c, p := toGroupPath(child), toGroupPath(parent)
+	if len(c) <= len(p) {
+		return false
+	}
+	for i := range p {
+		if c[i] != p[i] {
+			return false
+		}
+	}
+	return true
+}
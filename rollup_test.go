@@ -0,0 +1,132 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testPathRecord struct {
+	path GroupPath
+	id   string
+}
+
+func (r testPathRecord) Group() Group       { return r.path }
+func (r testPathRecord) Identifier() string { return r.id }
+
+func TestRollupStage(t *testing.T) {
+	tests := []struct {
+		name    string
+		records []Record
+		want    []Record
+	}{
+		{
+			name: "rollup",
+			records: []Record{
+				testPathRecord{GroupPath{"r1", "a1"}, "1"},
+				testPathRecord{GroupPath{"r1", "a1"}, "2"},
+				testPathRecord{GroupPath{"r1", "a2"}, "3"},
+				testPathRecord{GroupPath{"r2", "a1"}, "4"},
+			},
+			want: []Record{
+				testRecord{"r1/a1", "2"},
+				testRecord{"r1/a2", "1"},
+				testRecord{"r1", "3"},
+				testRecord{"r2/a1", "1"},
+				testRecord{"r2", "1"},
+				testRecord{"*", "4"},
+			},
+		},
+		{
+			name: "group commit",
+			records: []Record{
+				testPathRecord{GroupPath{"r1", "a1"}, "1"},
+				testPathRecord{GroupPath{"r1", "a2"}, "2"},
+				// ASSERT: 上位の階層のGroupCommitで、下位の全ての階層のグループもコミットされる
+				GroupCommit(GroupPath{"r1"}),
+				testPathRecord{GroupPath{"r2", "a1"}, "3"},
+				GroupCommit(GroupPath{"r2", "a1"}),
+			},
+			want: []Record{
+				testRecord{"r1/a1", "1"},
+				testRecord{"r1/a2", "1"},
+				testRecord{"r1", "2"},
+				testRecord{"r2/a1", "1"},
+				testRecord{"r2", "1"},
+				testRecord{"*", "3"},
+			},
+		},
+		{
+			name: "flat group",
+			records: []Record{
+				testRecord{"group1", "1"},
+				testRecord{"group1", "2"},
+				testRecord{"group2", "3"},
+			},
+			want: []Record{
+				testRecord{"group1", "2"},
+				testRecord{"group2", "1"},
+				testRecord{"*", "3"},
+			},
+		},
+		{
+			name: "group na",
+			records: []Record{
+				testRecord{"*", "1"},
+				testRecord{"a", "2"},
+			},
+			want: []Record{
+				testRecord{"a", "1"},
+				// ASSERT: GroupNAのレコードは全体のグループとして1回だけ集計される
+				testRecord{"*", "2"},
+			},
+		},
+		{
+			name: "escaped path",
+			records: []Record{
+				testPathRecord{GroupPath{"a/b"}, "1"},
+				testPathRecord{GroupPath{"a", "b"}, "2"},
+				testPathRecord{GroupPath{"*"}, "3"},
+			},
+			want: []Record{
+				// ASSERT: "/"や"*"を含む階層は、他のグループと区別して集計される
+				testRecord{`a\/b`, "1"},
+				testRecord{"a/b", "1"},
+				testRecord{"a", "1"},
+				testRecord{`\*`, "1"},
+				testRecord{"*", "3"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outputs, stages, err := New(
+				MapStage("Generator", &testSliceGenerator{records: tt.records}),
+				RollupFuncStage("Count", func(ctx context.Context, group Group, inputs []Record) ([]Record, error) {
+					return []Record{testRecord{group.String(), fmt.Sprint(len(inputs))}}, nil
+				}),
+			).Execute(context.Background())
+
+			assert.NoError(t, err)
+			assert.ElementsMatch(t, tt.want, outputs)
+			assert.Len(t, stages[1].Outputs, len(tt.want))
+		})
+	}
+}
+
+func TestGroupPath(t *testing.T) {
+	path := GroupPath{"r1", "a1"}
+
+	assert.Equal(t, "r1/a1", path.String())
+	assert.Equal(t, `a\/b/\\`, GroupPath{"a/b", `\`}.String())
+	assert.Equal(t, `\*`, GroupPath{"*"}.String())
+	assert.Equal(t, []Group{GroupPath{}}, rollupGroups(GroupNA))
+	assert.Equal(t, GroupPath{"r1"}, path.Parent())
+	assert.Equal(t, "*", path.Parent().Parent().String())
+	assert.True(t, isDescendantGroup(path, GroupPath{"r1"}))
+	assert.True(t, isDescendantGroup(path, GroupPath{}))
+	assert.False(t, isDescendantGroup(path, path))
+	assert.False(t, isDescendantGroup(path, GroupPath{"r2"}))
+}
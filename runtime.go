@@ -0,0 +1,625 @@
+package pipeline
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 保持しておく直近のエラーの件数
+const maxRecentErrors = 20
+
+// 実行中のステージの状態
+// パイプラインの実行ごとに作成され、contextを通じてProcessorに渡される
+// Processorを単体で実行した場合などはnilになるため、メソッドはnilでも安全に呼び出せるようにしておく
+type stageRuntime struct {
+	name        string
+	unitTimeout time.Duration
+	gate        gate
+	control     *runControl
+	breaker     *circuitBreaker
+	budget      *errorBudget
+	limiter     *adaptiveLimiter
+	weighted    *weightedSemaphore
+	pools       []ResourcePool
+	priority    PriorityFunc
+	queueSize   int
+	aging       time.Duration
+	queue       *recordQueue
+	combiner    Combiner
+	cancel      context.CancelFunc // ステージのcontextをキャンセルする
+	upstream    []*stageRuntime    // 前段のステージ
+
+	mu           sync.Mutex
+	progress     StageProgress
+	running      map[string]runningUnit
+	undelivered  map[string]int // 実行を開始し、まだ出力を後段に渡していない処理単位と、その数
+	bypassed     map[string]int // beginを経ずに出力され、まだ出力を後段に渡していない処理単位と、その数
+	partials     map[string]int // 実行中の処理単位の途中の出力で、まだ後段に渡していないものと、その数
+	recentErrors []UnitError
+}
+
+type runningUnit struct {
+	since time.Time
+	count int
+}
+
+func newStageRuntime(stage *PipelineStage, control *runControl, pools []ResourcePool) *stageRuntime {
+	var breaker *circuitBreaker
+	if stage.circuitBreaker != nil {
+		breaker = newCircuitBreaker(*stage.circuitBreaker)
+	}
+
+	var budget *errorBudget
+	if stage.maxErrors > 0 || stage.maxErrorRate > 0 {
+		budget = &errorBudget{
+			maxErrors:    stage.maxErrors,
+			maxErrorRate: stage.maxErrorRate,
+			minSamples:   stage.minSamples,
+		}
+	}
+
+	var limiter *adaptiveLimiter
+	if stage.adaptive != nil && stage.processor.Type() == ProcessorTypeMap {
+		config := *stage.adaptive
+		if stage.maxParallel > 0 {
+			// StageMaxParallelの値を並列数の上限として優先する
+			if config.MaxLimit <= 0 || config.MaxLimit > stage.maxParallel {
+				config.MaxLimit = stage.maxParallel
+			}
+			config.MinLimit = min(config.MinLimit, config.MaxLimit)
+		}
+		limiter = newAdaptiveLimiter(config)
+	}
+
+	var weighted *weightedSemaphore
+	if stage.weightCapacity > 0 && stage.weight != nil {
+		weighted = newWeightedSemaphore(stage.weightCapacity, stage.weight)
+	}
+
+	var priority PriorityFunc
+	var combiner Combiner
+	if stage.processor.Type() == ProcessorTypeMap {
+		priority = stage.priority
+		combiner = stage.combiner
+	}
+
+	return &stageRuntime{
+		name:        stage.processor.Name(),
+		unitTimeout: stage.unitTimeout,
+		control:     control,
+		breaker:     breaker,
+		budget:      budget,
+		limiter:     limiter,
+		weighted:    weighted,
+		pools:       pools,
+		priority:    priority,
+		queueSize:   stage.priorityQueueSize,
+		aging:       stage.priorityAging,
+		queue:       newRecordQueue(stage.bufferRecords, stage.bufferBytes, stage.recordSize),
+		combiner:    combiner,
+		progress: StageProgress{
+			Name: stage.processor.Name(),
+			Type: stage.processor.Type(),
+		},
+		running:     map[string]runningUnit{},
+		undelivered: map[string]int{},
+		bypassed:    map[string]int{},
+		partials:    map[string]int{},
+	}
+}
+
+type stageRuntimeKey struct{}
+
+func withStageRuntime(ctx context.Context, rt *stageRuntime) context.Context {
+	return context.WithValue(ctx, stageRuntimeKey{}, rt)
+}
+
+func stageRuntimeFrom(ctx context.Context) *stageRuntime {
+	rt, _ := ctx.Value(stageRuntimeKey{}).(*stageRuntime)
+	return rt
+}
+
+// 前段の全てのステージをキャンセルする
+func (rt *stageRuntime) stopUpstream() {
+	if rt == nil {
+		return
+	}
+	for _, up := range rt.upstream {
+		if up.cancel != nil {
+			up.cancel()
+		}
+	}
+}
+
+// バッファが設定されている場合は、入力をバッファリングするchannelを返す
+func (rt *stageRuntime) buffer(inputs <-chan Record) <-chan Record {
+	if rt == nil || rt.queue == nil {
+		return inputs
+	}
+	return rt.queue.run(inputs)
+}
+
+// Mapperの出力を集約するためのバッファを作成する。Combinerが設定されていない場合はnil
+func (rt *stageRuntime) combineBuffer() *combineBuffer {
+	if rt == nil {
+		return nil
+	}
+	return newCombineBuffer(rt.combiner)
+}
+
+// 入力のバッファに溜まったレコードの最大数
+func (rt *stageRuntime) maxQueued() int {
+	_, _, maxDepth := rt.queue.stats()
+	return maxDepth
+}
+
+// 優先度が設定されている場合は、入力を優先度の高い順に並べ替えたchannelを返す
+func (rt *stageRuntime) inputs(inputs <-chan Record) <-chan Record {
+	if rt == nil || rt.priority == nil {
+		return inputs
+	}
+	return prioritize(inputs, rt.priority, rt.queueSize, rt.aging)
+}
+
+// 処理単位（Mapperの場合はレコード、Reducerの場合はグループ）を受け取った
+func (rt *stageRuntime) received() {
+	if rt == nil {
+		return
+	}
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	rt.progress.Received++
+}
+
+// acquireで確保した実行枠
+// レコードごとに確保するため、クロージャを作らずに値として扱う
+type unitSlot struct {
+	limiter  *adaptiveLimiter   // 並列数の実行枠を確保した場合のみnon-nil
+	weighted *weightedSemaphore // 重みの分の実行枠を確保した場合のみnon-nil
+	weight   int64
+}
+
+// 確保した実行枠を、処理単位のレイテンシと実行結果のステータスとともに解放する
+func (s unitSlot) release(latency time.Duration, status OutputStatus) {
+	if s.weighted != nil {
+		s.weighted.release(s.weight)
+	}
+	if s.limiter != nil {
+		s.limiter.release(latency, status)
+	}
+}
+
+// 並列数を動的に調整する場合や重み付きで制限する場合に、実行枠が空くまで待機する
+// recordsには処理単位に含まれるレコードを渡す
+// 処理単位の実行が終了したら、戻り値のreleaseをレイテンシと実行結果のステータスとともに呼び出すこと
+func (rt *stageRuntime) acquire(ctx context.Context, records ...Record) (slot unitSlot) {
+	if rt == nil {
+		return slot
+	}
+
+	// ctxが終了した場合は実行枠を確保しないが、処理単位はスキップとして処理される
+	if rt.limiter != nil {
+		if err := rt.limiter.acquire(ctx); err != nil {
+			return slot
+		}
+		slot.limiter = rt.limiter
+	}
+	if rt.weighted != nil {
+		if n, err := rt.weighted.acquire(ctx, records...); err == nil {
+			slot.weighted = rt.weighted
+			slot.weight = n
+		}
+	}
+
+	return slot
+}
+
+// 処理単位の実行を開始する
+// パイプラインもしくはステージが一時停止されている間は、再開されるまで待機する
+// ステージがリソースプールを利用する場合は、利用枠が確保できるまで待機する
+// 実行を開始できない場合はスキップの理由となるエラーを返す
+// 実行が終了したら、戻り値のendを実行結果とともに呼び出すこと
+// endがエラーを返した場合は、全体の処理を中止するためにabortすること
+func (rt *stageRuntime) begin(ctx context.Context, unit string, drainable bool) (run unitRun, err error) {
+	if rt == nil {
+		return run, nil
+	}
+
+	// ドレイン中は一時停止されていても待機しない
+	rt.control.gate.wait(ctx, rt.control.drained)
+	rt.gate.wait(ctx, rt.control.drained)
+
+	// ドレイン中は新しい処理単位の実行を開始しない
+	if drainable && rt.control.isDraining() {
+		rt.bypass(unit)
+		return run, ErrDrained
+	}
+
+	// サーキットブレーカーがopenの間に共有のリソースプールの利用枠を占有しないよう、利用枠はブレーカーの判定後に確保する
+	var probe bool
+	if rt.breaker != nil {
+		probe, err = rt.breaker.allow(ctx)
+		if err != nil {
+			rt.bypass(unit)
+			return run, err
+		}
+	}
+
+	releasePools, err := rt.acquirePools(ctx)
+	if err != nil {
+		if rt.breaker != nil {
+			rt.breaker.record(probe, OutputStatusSkipped)
+		}
+		rt.bypass(unit)
+		return run, err
+	}
+
+	rt.started(unit)
+
+	return unitRun{rt: rt, unit: unit, probe: probe, releasePools: releasePools}, nil
+}
+
+// beginで実行を開始した処理単位
+// レコードごとに作成するため、クロージャを作らずに値として扱う
+type unitRun struct {
+	rt           *stageRuntime
+	unit         string
+	probe        bool   // サーキットブレーカーのhalf-openでの試行かどうか
+	releasePools func() // リソースプールを利用しない場合はnil
+}
+
+// 処理単位の実行が終了した
+func (u unitRun) end(o Output) error {
+	rt := u.rt
+	if rt == nil {
+		return nil
+	}
+
+	rt.finished(u.unit)
+	if u.releasePools != nil {
+		u.releasePools()
+	}
+	if rt.breaker != nil {
+		rt.breaker.record(u.probe, o.Status)
+	}
+	if rt.budget != nil {
+		if err := rt.budget.record(o.Status); err != nil {
+			return &AbortError{Stage: rt.name, Unit: u.unit, Err: err}
+		}
+	}
+	return nil
+}
+
+// ステージが利用するリソースプールの利用枠を、名前の順に確保する
+// 確保できなかった場合は、それまでに確保した利用枠を解放してエラーを返す
+// リソースプールを利用しない場合はnilを返す
+func (rt *stageRuntime) acquirePools(ctx context.Context) (release func(), err error) {
+	if len(rt.pools) == 0 {
+		return nil, nil
+	}
+
+	releases := []func(){}
+	release = func() {
+		for _, r := range releases {
+			r()
+		}
+	}
+
+	for _, pool := range rt.pools {
+		r, err := pool.Acquire(ctx)
+		if err != nil {
+			release()
+			return nil, err
+		}
+		releases = append(releases, r)
+	}
+	return release, nil
+}
+
+func (rt *stageRuntime) started(unit string) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	rt.progress.InFlight++
+	rt.undelivered[unit]++
+	if u, ok := rt.running[unit]; ok {
+		u.count++
+		rt.running[unit] = u
+	} else {
+		rt.running[unit] = runningUnit{since: time.Now(), count: 1}
+	}
+}
+
+func (rt *stageRuntime) finished(unit string) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	rt.progress.InFlight--
+	if u, ok := rt.running[unit]; ok {
+		u.count--
+		if u.count == 0 {
+			delete(rt.running, unit)
+		} else {
+			rt.running[unit] = u
+		}
+	}
+}
+
+// beginを経ずに処理単位の結果を出力する
+// 実行を開始できずにスキップした場合や、複数の処理単位の出力を集約した場合などに、出力する前に呼び出す
+func (rt *stageRuntime) bypass(unit string) {
+	if rt == nil {
+		return
+	}
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	rt.bypassed[unit]++
+}
+
+// 実行中の処理単位が、結果とは別にレコードを途中で出力する
+// 途中の出力はレコードの数のみ数え、処理単位の数には含めない。出力する前に呼び出す
+func (rt *stageRuntime) partial(unit string) {
+	if rt == nil {
+		return
+	}
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	rt.partials[unit]++
+}
+
+// 実行を開始し、まだ出力を後段に渡していない処理単位と、その数を返す
+// 実行が完了していても、出力を後段に渡すまでは含まれる
+func (rt *stageRuntime) undeliveredUnits() map[string]int {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	units := make(map[string]int, len(rt.undelivered))
+	for unit, n := range rt.undelivered {
+		units[unit] = n
+	}
+	return units
+}
+
+// 処理単位ごとのタイムアウトを設定したcontextを返す
+func (rt *stageRuntime) unitContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if rt == nil {
+		return withUnitTimeout(ctx, 0)
+	}
+	return withUnitTimeout(ctx, rt.unitTimeout)
+}
+
+// 処理単位の結果が出力された
+// beginで実行を開始した処理単位の出力の場合はtrueを返す
+func (rt *stageRuntime) output(o Output) (started bool) {
+	if rt == nil {
+		return true
+	}
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	for _, r := range o.Records {
+		if _, ok := r.(groupCommit); !ok {
+			rt.progress.Records++
+		}
+	}
+
+	// 実行中の処理単位の途中の出力は、処理単位の結果としては数えない
+	if n := rt.partials[o.Unit]; n > 0 {
+		if n > 1 {
+			rt.partials[o.Unit] = n - 1
+		} else {
+			delete(rt.partials, o.Unit)
+		}
+		return false
+	}
+
+	// beginを経ずに出力された処理単位は、同じ名前の実行中の処理単位と区別して数える
+	if n := rt.bypassed[o.Unit]; n > 0 {
+		if n > 1 {
+			rt.bypassed[o.Unit] = n - 1
+		} else {
+			delete(rt.bypassed, o.Unit)
+		}
+	} else {
+		started = true
+		if n := rt.undelivered[o.Unit]; n > 1 {
+			rt.undelivered[o.Unit] = n - 1
+		} else {
+			delete(rt.undelivered, o.Unit)
+		}
+	}
+
+	switch o.Status {
+	case OutputStatusSuccess:
+		rt.progress.Succeeded++
+	case OutputStatusCancelled:
+		rt.progress.Cancelled++
+	case OutputStatusSkipped:
+		rt.progress.Skipped++
+	case OutputStatusFiltered:
+		rt.progress.Filtered++
+	default:
+		rt.progress.Failed++
+	}
+
+	if o.Err != nil {
+		rt.recentErrors = append(rt.recentErrors, UnitError{
+			Unit:  o.Unit,
+			Error: o.Err.Error(),
+			At:    time.Now(),
+		})
+		if len(rt.recentErrors) > maxRecentErrors {
+			rt.recentErrors = rt.recentErrors[len(rt.recentErrors)-maxRecentErrors:]
+		}
+	}
+	return started
+}
+
+// 処理を開始したグループに、遅れてn件のレコードが到着した
+func (rt *stageRuntime) late(n int) {
+	if rt == nil {
+		return
+	}
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	rt.progress.Late += n
+}
+
+func (rt *stageRuntime) lateRecords() int {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	return rt.progress.Late
+}
+
+// ステージの全ての処理が完了した
+func (rt *stageRuntime) complete() {
+	if rt == nil {
+		return
+	}
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	rt.progress.Done = true
+}
+
+func (rt *stageRuntime) snapshot() StageProgress {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	return rt.progressLocked()
+}
+
+// 現在の進捗を返す。rt.muを取得した状態で呼び出すこと
+func (rt *stageRuntime) progressLocked() StageProgress {
+	p := rt.progress
+	p.Paused = rt.gate.isPaused() || rt.control.gate.isPaused()
+	if rt.breaker != nil {
+		p.CircuitBreaker = rt.breaker.currentState()
+	}
+	if rt.limiter != nil {
+		p.ConcurrencyLimit = rt.limiter.currentLimit()
+	}
+	p.Queued, p.QueuedBytes, _ = rt.queue.stats()
+	return p
+}
+
+func (rt *stageRuntime) status() StageStatus {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	p := rt.progressLocked()
+
+	units := make([]UnitStatus, 0, len(rt.running))
+	for unit, u := range rt.running {
+		units = append(units, UnitStatus{Unit: unit, Since: u.since})
+	}
+	sort.Slice(units, func(i, j int) bool {
+		return units[i].Since.Before(units[j].Since)
+	})
+
+	return StageStatus{
+		StageProgress: p,
+		RunningUnits:  units,
+		RecentErrors:  append([]UnitError{}, rt.recentErrors...),
+	}
+}
+
+// 処理単位の実行開始を一時停止するためのゲート
+type gate struct {
+	mu     sync.Mutex
+	paused chan struct{} // 一時停止中のみnon-nilで、再開時にcloseされる
+}
+
+func (g *gate) pause() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.paused == nil {
+		g.paused = make(chan struct{})
+	}
+}
+
+func (g *gate) resume() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.paused != nil {
+		close(g.paused)
+		g.paused = nil
+	}
+}
+
+func (g *gate) isPaused() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.paused != nil
+}
+
+// 一時停止中であれば、再開されるかctxが終了するかreleaseがcloseされるまで待機する
+func (g *gate) wait(ctx context.Context, release <-chan struct{}) {
+	g.mu.Lock()
+	paused := g.paused
+	g.mu.Unlock()
+
+	if paused == nil {
+		return
+	}
+
+	select {
+	case <-paused:
+	case <-ctx.Done():
+	case <-release:
+	}
+}
+
+// パイプライン全体の実行を制御する
+type runControl struct {
+	gate      gate
+	drainOnce sync.Once
+	drained   chan struct{}
+}
+
+func newRunControl() *runControl {
+	return &runControl{
+		drained: make(chan struct{}),
+	}
+}
+
+func (c *runControl) drain() {
+	c.drainOnce.Do(func() {
+		close(c.drained)
+	})
+}
+
+func (c *runControl) isDraining() bool {
+	select {
+	case <-c.drained:
+		return true
+	default:
+		return false
+	}
+}
+
+// サーキットブレーカーの状態遷移の履歴を返す
+func (rt *stageRuntime) circuitBreakerTransitions() []CircuitBreakerTransition {
+	if rt == nil || rt.breaker == nil {
+		return nil
+	}
+	return rt.breaker.history()
+}
+
+// 並列数の変化の履歴を返す
+func (rt *stageRuntime) concurrencyLimits() []ConcurrencyLimitChange {
+	if rt == nil || rt.limiter == nil {
+		return nil
+	}
+	return rt.limiter.changes()
+}
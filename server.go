@@ -0,0 +1,190 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+	"time"
+)
+
+// 実行中のパイプラインの状態
+type RunStatus struct {
+	Done     bool          `json:"done"`
+	Paused   bool          `json:"paused"`
+	Draining bool          `json:"draining"`
+	Stages   []StageStatus `json:"stages"`
+}
+
+// 実行中のステージの状態
+type StageStatus struct {
+	StageProgress
+	RunningUnits []UnitStatus `json:"runningUnits"` // 実行中の処理単位
+	RecentErrors []UnitError  `json:"recentErrors"` // 直近で発生したエラー
+}
+
+type UnitStatus struct {
+	Unit  string    `json:"unit"`
+	Since time.Time `json:"since"`
+}
+
+type UnitError struct {
+	Unit  string    `json:"unit"`
+	Error string    `json:"error"`
+	At    time.Time `json:"at"`
+}
+
+// 実行中のパイプラインの状態を返す
+func (r *Run) Status() RunStatus {
+	status := RunStatus{
+		Paused:   r.control.gate.isPaused(),
+		Draining: r.control.isDraining(),
+		Stages:   make([]StageStatus, 0, len(r.stages)),
+	}
+
+	select {
+	case <-r.done:
+		status.Done = true
+	default:
+	}
+
+	for _, rt := range r.stages {
+		status.Stages = append(status.Stages, rt.status())
+	}
+
+	return status
+}
+
+/*
+ * 実行中のパイプラインの状態を配信するhttp.Handlerを返す
+ *  - GET  /                         HTMLでの状態表示
+ *  - GET  /status                   JSONでの状態表示
+ *  - POST /cancel                   パイプラインのキャンセル
+ *  - POST /pause                    パイプラインの一時停止
+ *  - POST /resume                   パイプラインの再開
+ *  - POST /drain                    パイプラインのドレイン
+ *  - POST /stages/{name}/pause      ステージの一時停止
+ *  - POST /stages/{name}/resume     ステージの再開
+ * 任意のパスにマウントする場合はhttp.StripPrefixと組み合わせて利用すること
+ */
+func NewStatusHandler(r *Run) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := statusTemplate.Execute(w, r.Status()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, http.StatusOK, r.Status())
+	})
+
+	mux.HandleFunc("POST /cancel", func(w http.ResponseWriter, req *http.Request) {
+		r.Cancel()
+		writeJSON(w, http.StatusOK, r.Status())
+	})
+
+	mux.HandleFunc("POST /pause", func(w http.ResponseWriter, req *http.Request) {
+		r.Pause()
+		writeJSON(w, http.StatusOK, r.Status())
+	})
+
+	mux.HandleFunc("POST /resume", func(w http.ResponseWriter, req *http.Request) {
+		r.Resume()
+		writeJSON(w, http.StatusOK, r.Status())
+	})
+
+	mux.HandleFunc("POST /drain", func(w http.ResponseWriter, req *http.Request) {
+		r.Drain()
+		writeJSON(w, http.StatusOK, r.Status())
+	})
+
+	mux.HandleFunc("POST /stages/{name}/pause", func(w http.ResponseWriter, req *http.Request) {
+		handleStageControl(w, r, r.PauseStage(req.PathValue("name")))
+	})
+
+	mux.HandleFunc("POST /stages/{name}/resume", func(w http.ResponseWriter, req *http.Request) {
+		handleStageControl(w, r, r.ResumeStage(req.PathValue("name")))
+	})
+
+	return mux
+}
+
+func handleStageControl(w http.ResponseWriter, r *Run, err error) {
+	if errors.Is(err, ErrStageNotFound) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, r.Status())
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+var statusTemplate = template.Must(template.New("status").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+{{if not .Done}}<meta http-equiv="refresh" content="2">{{end}}
+<title>pipeline status</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+</style>
+<script>
+function post(path) {
+	fetch(path, { method: "POST" }).then(function () { location.reload(); });
+}
+</script>
+</head>
+<body>
+<h1>Pipeline {{if .Done}}(done){{else if .Draining}}(draining){{else if .Paused}}(paused){{else}}(running){{end}}</h1>
+{{if not .Done}}
+<p>
+{{if .Paused}}<button onclick="post('resume')">Resume</button>{{else}}<button onclick="post('pause')">Pause</button>{{end}}
+{{if not .Draining}}<button onclick="post('drain')">Drain</button>{{end}}
+<button onclick="post('cancel')">Cancel</button>
+</p>
+{{end}}
+<table>
+<tr><th>Stage</th><th>Type</th><th>Received</th><th>In flight</th><th>Succeeded</th><th>Failed</th><th>Cancelled</th><th>Skipped</th><th>Filtered</th><th>Records</th><th>Late</th><th>State</th><th></th></tr>
+{{range .Stages}}
+<tr>
+<td>{{.Name}}</td><td>{{.Type}}</td><td>{{.Received}}</td><td>{{.InFlight}}</td><td>{{.Succeeded}}</td><td>{{.Failed}}</td><td>{{.Cancelled}}</td><td>{{.Skipped}}</td><td>{{.Filtered}}</td><td>{{.Records}}</td><td>{{.Late}}</td>
+<td>{{if .Done}}done{{else if .Paused}}paused{{else}}running{{end}}</td>
+<td>{{if not .Done}}{{if .Paused}}<button onclick="post('stages/' + encodeURIComponent('{{.Name}}') + '/resume')">Resume</button>{{else}}<button onclick="post('stages/' + encodeURIComponent('{{.Name}}') + '/pause')">Pause</button>{{end}}{{end}}</td>
+</tr>
+{{end}}
+</table>
+{{range .Stages}}
+{{if or .RunningUnits .RecentErrors}}
+<h2>{{.Name}}</h2>
+{{if .RunningUnits}}
+<h3>Running units</h3>
+<table>
+<tr><th>Unit</th><th>Since</th></tr>
+{{range .RunningUnits}}<tr><td>{{.Unit}}</td><td>{{.Since.Format "2006-01-02 15:04:05"}}</td></tr>{{end}}
+</table>
+{{end}}
+{{if .RecentErrors}}
+<h3>Recent errors</h3>
+<table>
+<tr><th>Unit</th><th>Error</th><th>At</th></tr>
+{{range .RecentErrors}}<tr><td>{{.Unit}}</td><td>{{.Error}}</td><td>{{.At.Format "2006-01-02 15:04:05"}}</td></tr>{{end}}
+</table>
+{{end}}
+{{end}}
+{{end}}
+</body>
+</html>
+`))
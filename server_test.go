@@ -0,0 +1,109 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewStatusHandler(t *testing.T) {
+	run := New(
+		MapStage("Generator", &testGenerator{}),
+		MapStage("Block", &testBlockingMapper{}),
+	).Start(context.Background())
+	t.Cleanup(run.Cancel)
+
+	handler := NewStatusHandler(run)
+
+	// Blockステージで処理単位が実行中になるまで待つ
+	assert.Eventually(t, func() bool {
+		return run.Progress()[1].InFlight == 2
+	}, time.Second, 10*time.Millisecond)
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		wantCode int
+		check    func(t *testing.T, status RunStatus)
+	}{
+		{
+			name:     "status",
+			method:   http.MethodGet,
+			path:     "/status",
+			wantCode: http.StatusOK,
+			check: func(t *testing.T, status RunStatus) {
+				assert.False(t, status.Done)
+				assert.True(t, status.Stages[0].Done)
+				assert.ElementsMatch(t, []string{"group1/id1", "error/id2"}, []string{
+					status.Stages[1].RunningUnits[0].Unit,
+					status.Stages[1].RunningUnits[1].Unit,
+				})
+			},
+		},
+		{
+			name:     "html",
+			method:   http.MethodGet,
+			path:     "/",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "pause",
+			method:   http.MethodPost,
+			path:     "/stages/Block/pause",
+			wantCode: http.StatusOK,
+			check: func(t *testing.T, status RunStatus) {
+				assert.True(t, status.Stages[1].Paused)
+			},
+		},
+		{
+			name:     "resume",
+			method:   http.MethodPost,
+			path:     "/stages/Block/resume",
+			wantCode: http.StatusOK,
+			check: func(t *testing.T, status RunStatus) {
+				assert.False(t, status.Stages[1].Paused)
+			},
+		},
+		{
+			name:     "unknown stage",
+			method:   http.MethodPost,
+			path:     "/stages/Unknown/pause",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "cancel",
+			method:   http.MethodPost,
+			path:     "/cancel",
+			wantCode: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+
+			assert.Equal(t, tt.wantCode, rec.Code)
+			if tt.check != nil {
+				var status RunStatus
+				assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &status))
+				tt.check(t, status)
+			}
+		})
+	}
+
+	// キャンセル後はパイプラインが完了し、エラーが記録されている
+	_, stages, err := run.Wait()
+	assert.NoError(t, err)
+	assert.Len(t, stages, 2)
+
+	status := run.Status()
+	assert.True(t, status.Done)
+	assert.Len(t, status.Stages[1].RecentErrors, 2)
+	assert.Equal(t, context.Canceled.Error(), status.Stages[1].RecentErrors[0].Error)
+}
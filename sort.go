@@ -0,0 +1,351 @@
+package pipeline
+
+import (
+	"bufio"
+	"container/heap"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// ソート済みのレコードを後段に渡す際に、1つの出力にまとめる件数
+const sortOutputChunkSize = 1000
+
+// SortConfigの設定が誤っている場合に、パイプラインの開始時に返すエラー
+var ErrInvalidSortConfig = errors.New("invalid sort config")
+
+// レコードを一時ファイルに書き出すためのエンコーダー / デコーダー
+type RecordCodec interface {
+	Marshal(r Record) ([]byte, error)
+	Unmarshal(data []byte) (Record, error)
+}
+
+type SortConfig struct {
+	// メモリ上に保持するレコードの上限件数
+	// 超えた場合はソート済みのレコードを一時ファイルに書き出し、最後にマージする。0の場合は全てのレコードをメモリ上でソートする
+	MaxRecordsInMemory int
+	// 一時ファイルに書き出す際に利用するコーデック。MaxRecordsInMemoryを指定する場合は必須
+	// メタデータを取り除いた元のレコードが渡されるため、一時ファイルに書き出したレコードのメタデータは失われる
+	Codec RecordCodec
+	// 一時ファイルを作成するディレクトリ。空の場合はos.TempDir()
+	TempDir string
+}
+
+// 全てのレコードをlessで並べ替えて後段に渡すステージを組み立てる
+// 同じ順序のレコードは受け取った順序が保たれる
+// ソート済みのレコードは、先頭から1000件ずつ順に出力され、最後に全体の処理単位"*"の結果が出力される
+// MaxRecordsInMemoryを指定してCodecを指定しない場合は、パイプラインの開始時にErrInvalidSortConfigを返す
+func SortStage(name string, less func(a, b Record) bool, config SortConfig, opts ...PipelineStageOption) *PipelineStage {
+	stage := Stage(&sortProcessor{
+		name:   name,
+		less:   less,
+		config: config,
+	}, opts...)
+	if config.MaxRecordsInMemory > 0 && config.Codec == nil {
+		stage.err = fmt.Errorf("%w: codec is required when MaxRecordsInMemory is set", ErrInvalidSortConfig)
+	}
+	return stage
+}
+
+type sortProcessor struct {
+	name            string
+	less            func(a, b Record) bool
+	config          SortConfig
+	abortIfAnyError bool
+}
+
+// 全てのレコードを1つの処理単位として処理するため、並列数の設定は無視する
+func (p *sortProcessor) SetMaxParallel(max int) {}
+
+func (p *sortProcessor) SetAbortIfAnyError(value bool) {
+	p.abortIfAnyError = value
+}
+
+func (p *sortProcessor) Name() string {
+	return p.name
+}
+
+func (p *sortProcessor) Type() ProcessorType {
+	return ProcessorTypeReduce
+}
+
+func (p *sortProcessor) Process(ctx context.Context, inputs <-chan Record, abort chan<- error) <-chan Output {
+	outputs := make(chan Output)
+
+	rt := stageRuntimeFrom(ctx)
+
+	go func() {
+		defer close(outputs)
+
+		// lessにはメタデータを取り除いた元のレコードを渡す
+		less := func(a, b Record) bool {
+			return p.less(Unwrap(a), Unwrap(b))
+		}
+		s := &externalSorter{less: less, config: p.config}
+		defer s.close()
+
+		var err error
+		for in := range inputs {
+			if _, ok := in.(groupCommit); ok {
+				continue
+			}
+			// エラーが発生した場合も、前段をブロックしないよう入力は最後まで読み込む
+			if err == nil {
+				err = s.add(in)
+			}
+		}
+
+		rt.received()
+
+		running, beginErr := rt.begin(ctx, GroupNA.String(), false)
+		if beginErr != nil {
+			outputs <- Output{
+				Unit:   GroupNA.String(),
+				Status: OutputStatusSkipped,
+				Err:    beginErr,
+			}
+			return
+		}
+
+		// ソート済みのレコードは処理単位"*"の途中の出力として渡し、最後に処理単位"*"の結果を出力する
+		output := Output{Unit: GroupNA.String(), Status: OutputStatusSuccess}
+		if err == nil {
+			err = s.merge(ctx, func(from, to int, records []Record) {
+				chunk := Output{
+					Unit:    fmt.Sprintf("%d-%d", from, to),
+					Status:  OutputStatusSuccess,
+					Records: records,
+				}
+				rt.partial(chunk.Unit)
+				outputs <- chunk
+			})
+		}
+		if err != nil {
+			output = Output{
+				Unit:   GroupNA.String(),
+				Status: errorStatus(ctx, err),
+				Err:    err,
+			}
+		}
+		outputs <- output
+
+		abortErr := running.end(output)
+		if p.abortIfAnyError && output.Status.IsFailure() {
+			abortErr = &AbortError{Stage: p.name, Unit: output.Unit, Err: err}
+		}
+		if abortErr != nil {
+			abort <- abortErr
+		}
+	}()
+
+	return outputs
+}
+
+// メモリ上に保持するレコードが上限を超えた場合に、ソート済みの一時ファイル（ラン）に書き出しながらソートする
+type externalSorter struct {
+	less   func(a, b Record) bool
+	config SortConfig
+
+	buffer []Record
+	runs   []*os.File
+}
+
+func (s *externalSorter) add(r Record) error {
+	s.buffer = append(s.buffer, r)
+	if s.config.MaxRecordsInMemory > 0 && len(s.buffer) >= s.config.MaxRecordsInMemory {
+		return s.spill()
+	}
+	return nil
+}
+
+// メモリ上のレコードをソートして一時ファイルに書き出す
+func (s *externalSorter) spill() error {
+	if s.config.Codec == nil {
+		return errors.New("sort: codec is required to spill records")
+	}
+
+	sort.SliceStable(s.buffer, func(i, j int) bool {
+		return s.less(s.buffer[i], s.buffer[j])
+	})
+
+	f, err := os.CreateTemp(s.config.TempDir, "pipeline-sort-*")
+	if err != nil {
+		return fmt.Errorf("sort: create temp file: %w", err)
+	}
+	s.runs = append(s.runs, f)
+
+	w := bufio.NewWriter(f)
+	for _, r := range s.buffer {
+		data, err := s.config.Codec.Marshal(Unwrap(r))
+		if err != nil {
+			return fmt.Errorf("sort: marshal record %s: %w", RecordKey(r), err)
+		}
+		if _, err := w.Write(binary.AppendUvarint(nil, uint64(len(data)))); err != nil {
+			return fmt.Errorf("sort: write temp file: %w", err)
+		}
+		if _, err := w.Write(data); err != nil {
+			return fmt.Errorf("sort: write temp file: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("sort: write temp file: %w", err)
+	}
+
+	s.buffer = nil
+	return nil
+}
+
+// 一時ファイルとメモリ上のレコードをマージし、ソート済みのレコードをsortOutputChunkSize件ずつemitに渡す
+// from, toはソート済みのレコード全体での位置（1始まり）
+func (s *externalSorter) merge(ctx context.Context, emit func(from, to int, records []Record)) error {
+	sort.SliceStable(s.buffer, func(i, j int) bool {
+		return s.less(s.buffer[i], s.buffer[j])
+	})
+
+	h := &runHeap{less: s.less}
+	for i, f := range s.runs {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("sort: read temp file: %w", err)
+		}
+		run := &fileRun{reader: bufio.NewReader(f), codec: s.config.Codec}
+		if err := h.pushNext(i, run); err != nil {
+			return err
+		}
+	}
+	// メモリ上のレコードは最後に受け取ったものなので、最後のランとして扱う
+	if err := h.pushNext(len(s.runs), &sliceRun{records: s.buffer}); err != nil {
+		return err
+	}
+
+	position := 0
+	chunk := []Record{}
+	for h.Len() > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		item := h.items[0]
+		chunk = append(chunk, item.record)
+		if err := h.advance(); err != nil {
+			return err
+		}
+
+		if len(chunk) == sortOutputChunkSize {
+			emit(position+1, position+len(chunk), chunk)
+			position += len(chunk)
+			chunk = []Record{}
+		}
+	}
+	if len(chunk) > 0 {
+		emit(position+1, position+len(chunk), chunk)
+	}
+	return nil
+}
+
+func (s *externalSorter) close() {
+	for _, f := range s.runs {
+		f.Close()
+		os.Remove(f.Name())
+	}
+}
+
+// ソート済みのレコードを先頭から順に読み出す
+type sortedRun interface {
+	// 次のレコードを返す。全て読み出した場合はio.EOFを返す
+	next() (Record, error)
+}
+
+type sliceRun struct {
+	records []Record
+}
+
+func (r *sliceRun) next() (Record, error) {
+	if len(r.records) == 0 {
+		return nil, io.EOF
+	}
+	record := r.records[0]
+	r.records = r.records[1:]
+	return record, nil
+}
+
+type fileRun struct {
+	reader *bufio.Reader
+	codec  RecordCodec
+}
+
+func (r *fileRun) next() (Record, error) {
+	size, err := binary.ReadUvarint(r.reader)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("sort: read temp file: %w", err)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r.reader, data); err != nil {
+		return nil, fmt.Errorf("sort: read temp file: %w", err)
+	}
+	record, err := r.codec.Unmarshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("sort: unmarshal record: %w", err)
+	}
+	return record, nil
+}
+
+type runHeapItem struct {
+	record Record
+	index  int // 同じ順序のレコードを受け取った順序で出力するための、ランの番号
+	run    sortedRun
+}
+
+// 各ランの先頭のレコードを保持し、最も前に並ぶレコードを取り出すためのヒープ
+type runHeap struct {
+	less  func(a, b Record) bool
+	items []runHeapItem
+}
+
+// ランの次のレコードをヒープに追加する
+func (h *runHeap) pushNext(index int, run sortedRun) error {
+	record, err := run.next()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	heap.Push(h, runHeapItem{record: record, index: index, run: run})
+	return nil
+}
+
+// 先頭のレコードを取り除き、同じランの次のレコードに置き換える
+func (h *runHeap) advance() error {
+	item := heap.Pop(h).(runHeapItem)
+	return h.pushNext(item.index, item.run)
+}
+
+func (h *runHeap) Len() int { return len(h.items) }
+
+func (h *runHeap) Less(i, j int) bool {
+	a, b := h.items[i], h.items[j]
+	if h.less(a.record, b.record) {
+		return true
+	}
+	if h.less(b.record, a.record) {
+		return false
+	}
+	return a.index < b.index
+}
+
+func (h *runHeap) Swap(i, j int) { h.items[i], h.items[j] = h.items[j], h.items[i] }
+
+func (h *runHeap) Push(x any) { h.items = append(h.items, x.(runHeapItem)) }
+
+func (h *runHeap) Pop() any {
+	item := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return item
+}
@@ -0,0 +1,127 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testRecordCodec struct{}
+
+func (c testRecordCodec) Marshal(r Record) ([]byte, error) {
+	return []byte(RecordKey(r)), nil
+}
+
+func (c testRecordCodec) Unmarshal(data []byte) (Record, error) {
+	group, id, _ := strings.Cut(string(data), "/")
+	return testRecord{group, id}, nil
+}
+
+func testIdentifierAsc(a, b Record) bool {
+	x, _ := strconv.Atoi(a.Identifier())
+	y, _ := strconv.Atoi(b.Identifier())
+	return x < y
+}
+
+func TestSortStage(t *testing.T) {
+	records := []Record{}
+	for _, i := range []int{5, 3, 9, 1, 7, 3, 8, 2, 6, 4} {
+		records = append(records, testRecord{fmt.Sprintf("group%d", len(records)), fmt.Sprint(i)})
+	}
+
+	tests := []struct {
+		name    string
+		config  SortConfig
+		wantErr bool
+	}{
+		{
+			name:   "in memory",
+			config: SortConfig{},
+		},
+		{
+			name:   "spill",
+			config: SortConfig{MaxRecordsInMemory: 3, Codec: testRecordCodec{}},
+		},
+		{
+			name:    "spill without codec",
+			config:  SortConfig{MaxRecordsInMemory: 3},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.TempDir = t.TempDir()
+
+			r := New(
+				MapStage("Generator", &testSliceGenerator{records: records}, StageMaxParallel(1)),
+				SortStage("Sort", testIdentifierAsc, tt.config),
+			).Start(context.Background())
+			outputs, stages, err := r.Wait()
+
+			if tt.wantErr {
+				// ASSERT: コーデックの指定漏れはパイプラインの開始時に検出される
+				assert.ErrorIs(t, err, ErrInvalidSortConfig)
+				assert.Empty(t, outputs)
+				return
+			}
+			assert.NoError(t, err)
+
+			ids := []string{}
+			for _, o := range outputs {
+				ids = append(ids, RecordKey(o))
+			}
+			// ASSERT: 同じ順序のレコード（3）は受け取った順序が保たれる
+			assert.Equal(t, []string{
+				"group3/1", "group7/2", "group1/3", "group5/3", "group9/4",
+				"group0/5", "group8/6", "group4/7", "group6/8", "group2/9",
+			}, ids)
+			assert.Equal(t, []SummarizedOutput{
+				{Unit: "1-10", Status: OutputStatusSuccess, RecordCount: 10, GroupCount: 10},
+				{Unit: "*", Status: OutputStatusSuccess},
+			}, stages[1].Outputs)
+
+			// ASSERT: ソート済みのレコードの出力は、処理単位"*"の途中の出力として数えられる
+			progress := r.Progress()[1]
+			assert.Equal(t, 1, progress.Received)
+			assert.Equal(t, 1, progress.Succeeded)
+			assert.Equal(t, 10, progress.Records)
+			assert.Empty(t, r.stages[1].undeliveredUnits())
+
+			// ASSERT: 一時ファイルは削除される
+			entries, err := os.ReadDir(tt.config.TempDir)
+			assert.NoError(t, err)
+			assert.Empty(t, entries)
+		})
+	}
+}
+
+func TestExternalSorter_Chunk(t *testing.T) {
+	s := &externalSorter{
+		less:   testIdentifierAsc,
+		config: SortConfig{MaxRecordsInMemory: 700, Codec: testRecordCodec{}, TempDir: t.TempDir()},
+	}
+	defer s.close()
+
+	for i := range 2500 {
+		assert.NoError(t, s.add(testRecord{"group", fmt.Sprint(2500 - i)}))
+	}
+	assert.Len(t, s.runs, 3)
+
+	units := []string{}
+	prev := 0
+	err := s.merge(context.Background(), func(from, to int, records []Record) {
+		units = append(units, fmt.Sprintf("%d-%d", from, to))
+		for _, r := range records {
+			id, _ := strconv.Atoi(r.Identifier())
+			assert.Greater(t, id, prev)
+			prev = id
+		}
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1-1000", "1001-2000", "2001-2500"}, units)
+}
@@ -3,8 +3,31 @@ package pipeline
 import "time"
 
 type PipelineStage struct {
-	processor Processor
-	timeout   time.Duration
+	processor      Processor
+	maxParallel    int
+	timeout        time.Duration
+	unitTimeout    time.Duration
+	circuitBreaker *CircuitBreakerConfig
+	maxErrors      int
+	maxErrorRate   float64
+	minSamples     int
+	adaptive       *AdaptiveConcurrencyConfig
+	weightCapacity int64
+	weight         WeightFunc
+	resourcePools  []string
+
+	priority          PriorityFunc
+	priorityQueueSize int
+	priorityAging     time.Duration
+
+	bufferRecords int
+	bufferBytes   int64
+	recordSize    RecordSizeFunc
+	combiner      Combiner
+
+	commitWaitInFlight bool
+
+	err error // ステージの組み立て時に検出した設定の誤り。パイプラインの開始時に返す
 }
 
 type PipelineStageOption func(*PipelineStage)
@@ -34,25 +57,127 @@ func ReduceStage(name string, reducer Reducer, opts ...PipelineStageOption) *Pip
 /* 実行時オプション */
 func StageMaxParallel(max int) PipelineStageOption {
 	return func(s *PipelineStage) {
+		s.maxParallel = max
 		s.processor.SetMaxParallel(max)
 	}
 }
 
+// Mapperの並列数を、処理単位のレイテンシとエラーの発生状況に応じて実行時に調整する
+// StageMaxParallelと併用した場合は、StageMaxParallelの値が並列数の上限として優先される
+// Reducerに対しては何もしない
+func StageAdaptiveParallel(config AdaptiveConcurrencyConfig) PipelineStageOption {
+	return func(s *PipelineStage) {
+		s.adaptive = &config
+	}
+}
+
 func StageAbortIfAnyError(value bool) PipelineStageOption {
 	return func(s *PipelineStage) {
 		s.processor.SetAbortIfAnyError(value)
 	}
 }
 
+// 失敗（エラーもしくはタイムアウト）した処理単位の数がnを超えた場合、全体の処理を中止する
+// StageAbortIfAnyErrorと異なり、一定数までの失敗は許容される
+func StageMaxErrors(n int) PipelineStageOption {
+	return func(s *PipelineStage) {
+		s.maxErrors = n
+	}
+}
+
+// 成功もしくは失敗した処理単位の数がminSamples以上になった時点で、失敗の割合がratioを超えていた場合、全体の処理を中止する
+func StageAbortIfErrorRateExceeds(ratio float64, minSamples int) PipelineStageOption {
+	return func(s *PipelineStage) {
+		s.maxErrorRate = ratio
+		s.minSamples = minSamples
+	}
+}
+
 func StageTimeout(timeout time.Duration) PipelineStageOption {
 	return func(s *PipelineStage) {
 		s.timeout = timeout
 	}
 }
 
+// 処理単位（Mapperの場合はレコード、Reducerの場合はグループ）ごとのタイムアウト
+// StageTimeoutと併用した場合、ステージ全体のタイムアウトの範囲内で適用される
+func StageUnitTimeout(timeout time.Duration) PipelineStageOption {
+	return func(s *PipelineStage) {
+		s.unitTimeout = timeout
+	}
+}
+
+// 依存先の障害時に大量の失敗を発生させないよう、失敗が続いた場合に処理単位の実行を止める
+// open中は処理単位をErrCircuitOpenでスキップし（WaitWhenOpenの場合は待機し）、
+// OpenDuration経過後にhalf-openとして1件だけ試行して、成功すれば再開する
+func StageCircuitBreaker(config CircuitBreakerConfig) PipelineStageOption {
+	return func(s *PipelineStage) {
+		s.circuitBreaker = &config
+	}
+}
+
+// 前段の複数の処理単位が同じグループのレコードを出力する場合に、GroupCommitを受け取った時点で
+// 前段で実行中だった処理単位の出力が届くまで、Reducerでグループの処理を開始しない
+// 最初のGroupCommitでグループの処理を開始し、並行して実行中の他の処理単位のレコードが遅れて到着することを防ぐために利用する
+// GroupCommitを受け取った後に実行を開始した処理単位は待たないため、全ての出力を待つ場合はGroupCommitWithCountを利用すること
+func StageGroupCommitWaitInFlight() PipelineStageOption {
+	return func(s *PipelineStage) {
+		s.commitWaitInFlight = true
+	}
+}
+
 // ステージの実行結果
 type StageExecution struct {
 	Name    string
 	Type    ProcessorType
 	Outputs []SummarizedOutput
+
+	// サーキットブレーカーの状態遷移。StageCircuitBreakerを設定した場合のみ記録される
+	CircuitBreakerTransitions []CircuitBreakerTransition
+	// 並列数の変化。StageAdaptiveParallelを設定した場合のみ記録される
+	ConcurrencyLimits []ConcurrencyLimitChange
+	// 入力のバッファに溜まったレコードの最大数。StageBufferもしくはStageBufferBytesを設定した場合のみ記録される
+	MaxQueued int
+	// Reducerで、処理を開始したグループに遅れて到着したレコードの数
+	LateRecords int
+}
+
+// 指定したステータスの処理単位の数を返す
+func (e StageExecution) Count(status OutputStatus) int {
+	count := 0
+	for _, o := range e.Outputs {
+		if o.Status == status {
+			count++
+		}
+	}
+	return count
+}
+
+// ステータスごとの処理単位の数を返す
+func (e StageExecution) CountByStatus() map[OutputStatus]int {
+	counts := map[OutputStatus]int{}
+	for _, o := range e.Outputs {
+		counts[o.Status]++
+	}
+	return counts
+}
+
+// 失敗（エラーもしくはタイムアウト）した処理単位の数を返す
+func (e StageExecution) FailureCount() int {
+	count := 0
+	for _, o := range e.Outputs {
+		if o.Status.IsFailure() {
+			count++
+		}
+	}
+	return count
+}
+
+// 後段に出力したレコードの数を返す
+func (e StageExecution) RecordCount() int {
+	count := 0
+	for _, o := range e.Outputs {
+		count += o.RecordCount
+	}
+	return count
 }
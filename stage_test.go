@@ -0,0 +1,52 @@
+package pipeline
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStageExecution_Count(t *testing.T) {
+	tests := []struct {
+		name             string
+		execution        StageExecution
+		wantByStatus     map[OutputStatus]int
+		wantFailureCount int
+		wantRecordCount  int
+	}{
+		{
+			name: "happy path",
+			execution: StageExecution{
+				Outputs: []SummarizedOutput{
+					{Status: OutputStatusSuccess, RecordCount: 2},
+					{Status: OutputStatusSuccess, RecordCount: 1},
+					{Status: OutputStatusError},
+					{Status: OutputStatusTimeout},
+					{Status: OutputStatusCancelled},
+					{Status: OutputStatusSkipped},
+					{Status: OutputStatusFiltered},
+				},
+			},
+			wantByStatus: map[OutputStatus]int{
+				OutputStatusSuccess:   2,
+				OutputStatusError:     1,
+				OutputStatusTimeout:   1,
+				OutputStatusCancelled: 1,
+				OutputStatusSkipped:   1,
+				OutputStatusFiltered:  1,
+			},
+			wantFailureCount: 2,
+			wantRecordCount:  3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.wantByStatus, tt.execution.CountByStatus())
+			for status, count := range tt.wantByStatus {
+				assert.Equal(t, count, tt.execution.Count(status))
+			}
+			assert.Equal(t, tt.wantFailureCount, tt.execution.FailureCount())
+			assert.Equal(t, tt.wantRecordCount, tt.execution.RecordCount())
+		})
+	}
+}
@@ -0,0 +1,23 @@
+package pipeline
+
+import "context"
+
+// パイプラインの実行ごとにnewMapperでMapperを作成するProcessor
+// 重複排除など、実行中に状態を持つ組み込みのステージで利用する
+type statefulMapProcessor struct {
+	*mapProcessor
+	newMapper func() Mapper
+}
+
+func newStatefulMapProcessor(name string, newMapper func() Mapper) *statefulMapProcessor {
+	return &statefulMapProcessor{
+		mapProcessor: newMapProcessor(name, nil),
+		newMapper:    newMapper,
+	}
+}
+
+func (p *statefulMapProcessor) Process(ctx context.Context, inputs <-chan Record, abort chan<- error) <-chan Output {
+	m := *p.mapProcessor
+	m.mapper = p.newMapper()
+	return m.Process(ctx, inputs, abort)
+}
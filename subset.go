@@ -0,0 +1,97 @@
+package pipeline
+
+import (
+	"context"
+	"hash/fnv"
+	"math/rand/v2"
+	"sort"
+	"sync/atomic"
+)
+
+// 全てのレコードをlessで並べ替え、先頭のk件を後段に渡すステージを組み立てる
+// 全てのレコードを受け取ってから1つの処理単位として処理する
+// kが0以下の場合は、何も後段に渡さない
+func TopKStage(name string, k int, less func(a, b Record) bool, opts ...PipelineStageOption) *PipelineStage {
+	return Stage(newReduceProcessor(name, ReducerFunc(func(ctx context.Context, group Group, inputs []Record) ([]Record, error) {
+		return topK(inputs, k, less), nil
+	}), reduceAll()), opts...)
+}
+
+// グループごとにレコードをlessで並べ替え、先頭のk件を後段に渡すステージを組み立てる
+// kが0以下の場合は、何も後段に渡さない
+func GroupTopKStage(name string, k int, less func(a, b Record) bool, opts ...PipelineStageOption) *PipelineStage {
+	return ReduceFuncStage(name, func(ctx context.Context, group Group, inputs []Record) ([]Record, error) {
+		return topK(inputs, k, less), nil
+	}, opts...)
+}
+
+// 全てのレコードから無作為にn件を選んで後段に渡すステージを組み立てる
+// 同じseedと同じレコードの組み合わせに対しては、レコードを受け取った順序によらず同じ結果になる
+func SampleStage(name string, n int, seed uint64, opts ...PipelineStageOption) *PipelineStage {
+	return Stage(newReduceProcessor(name, ReducerFunc(func(ctx context.Context, group Group, inputs []Record) ([]Record, error) {
+		return sample(inputs, n, seed), nil
+	}), reduceAll()), opts...)
+}
+
+// グループごとに無作為にn件を選んで後段に渡すステージを組み立てる
+func GroupSampleStage(name string, n int, seed uint64, opts ...PipelineStageOption) *PipelineStage {
+	return ReduceFuncStage(name, func(ctx context.Context, group Group, inputs []Record) ([]Record, error) {
+		h := fnv.New64a()
+		h.Write([]byte(group.String()))
+		return sample(inputs, n, seed^h.Sum64()), nil
+	}, opts...)
+}
+
+// 最初のn件のレコードのみを後段に渡すステージを組み立てる
+// n件に達した時点で前段のステージの処理をキャンセルし、それ以降のレコードはOutputStatusFilteredとして除外する
+func LimitStage(name string, n int, opts ...PipelineStageOption) *PipelineStage {
+	return Stage(newStatefulMapProcessor(name, func() Mapper {
+		return &limitMapper{limit: int64(n)}
+	}), opts...)
+}
+
+type limitMapper struct {
+	limit int64
+	count atomic.Int64
+}
+
+func (m *limitMapper) Map(ctx context.Context, input Record) ([]Record, error) {
+	count := m.count.Add(1)
+	if count >= m.limit {
+		StopUpstream(ctx)
+	}
+	if count > m.limit {
+		return nil, ErrFiltered
+	}
+	return []Record{input}, nil
+}
+
+func topK(inputs []Record, k int, less func(a, b Record) bool) []Record {
+	sorted := append([]Record{}, inputs...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return less(sorted[i], sorted[j])
+	})
+	return sorted[:max(min(k, len(sorted)), 0)]
+}
+
+// リザーバサンプリングでn件を選ぶ
+func sample(inputs []Record, n int, seed uint64) []Record {
+	// 受け取った順序によらず同じ結果になるよう、RecordKeyの順に並べてから選ぶ
+	sorted := append([]Record{}, inputs...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return RecordKey(sorted[i]) < RecordKey(sorted[j])
+	})
+
+	rnd := rand.New(rand.NewPCG(seed, seed))
+	reservoir := []Record{}
+	for i, in := range sorted {
+		if i < n {
+			reservoir = append(reservoir, in)
+			continue
+		}
+		if j := rnd.IntN(i + 1); j < n {
+			reservoir[j] = in
+		}
+	}
+	return reservoir
+}
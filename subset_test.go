@@ -0,0 +1,125 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testIdentifierDesc(a, b Record) bool {
+	x, _ := strconv.Atoi(a.Identifier())
+	y, _ := strconv.Atoi(b.Identifier())
+	return x > y
+}
+
+func TestSubsetStages(t *testing.T) {
+	records := []Record{
+		testRecord{"group1", "3"},
+		testRecord{"group1", "9"},
+		GroupCommit(GroupString("group1")),
+		testRecord{"group2", "5"},
+		testRecord{"group2", "1"},
+		testRecord{"group2", "7"},
+	}
+
+	tests := []struct {
+		name  string
+		stage *PipelineStage
+		want  []Record
+	}{
+		{
+			name:  "top k",
+			stage: TopKStage("TopK", 2, testIdentifierDesc),
+			want: []Record{
+				testRecord{"group1", "9"},
+				testRecord{"group2", "7"},
+			},
+		},
+		{
+			name:  "negative top k",
+			stage: GroupTopKStage("TopK", -1, testIdentifierDesc),
+			// ASSERT: kが負の場合も、panicせずに何も出力しない
+			want: []Record{},
+		},
+		{
+			name:  "group top k",
+			stage: GroupTopKStage("TopK", 1, testIdentifierDesc),
+			want: []Record{
+				testRecord{"group1", "9"},
+				testRecord{"group2", "7"},
+			},
+		},
+		{
+			name:  "sample",
+			stage: SampleStage("Sample", 5, 1),
+			// ASSERT: 件数がn以下の場合は全てのレコードが選ばれる
+			want: []Record{
+				testRecord{"group1", "3"},
+				testRecord{"group1", "9"},
+				testRecord{"group2", "5"},
+				testRecord{"group2", "1"},
+				testRecord{"group2", "7"},
+			},
+		},
+		{
+			name:  "limit",
+			stage: LimitStage("Limit", 2, StageMaxParallel(1)),
+			want: []Record{
+				testRecord{"group1", "3"},
+				testRecord{"group1", "9"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outputs, _, err := New(
+				MapStage("Generator", &testSliceGenerator{records: records}),
+				tt.stage,
+			).Execute(context.Background())
+
+			assert.NoError(t, err)
+			assert.ElementsMatch(t, tt.want, outputs)
+		})
+	}
+}
+
+func TestSample(t *testing.T) {
+	records := []Record{}
+	for i := range 100 {
+		records = append(records, testRecord{"group", fmt.Sprint(i)})
+	}
+	reversed := slices.Clone(records)
+	slices.Reverse(reversed)
+
+	got := sample(records, 10, 42)
+	assert.Len(t, got, 10)
+	// ASSERT: 同じseedであれば、レコードを受け取った順序によらず同じ結果になる
+	assert.Equal(t, got, sample(reversed, 10, 42))
+	assert.NotEqual(t, got, sample(records, 10, 43))
+}
+
+func TestLimitStage_StopUpstream(t *testing.T) {
+	records := []Record{}
+	for i := range 100 {
+		records = append(records, testRecord{"group", fmt.Sprint(i)})
+	}
+
+	outputs, stages, err := New(
+		MapStage("Generator", &testSliceGenerator{records: records}),
+		MapStage("Slow", &testSlowMapper{}, StageMaxParallel(2)),
+		LimitStage("Limit", 3),
+	).Execute(context.Background())
+
+	assert.NoError(t, err)
+	assert.Len(t, outputs, 3)
+
+	// ASSERT: 必要な件数が揃った時点で前段の処理がキャンセルされ、残りの処理単位はスキップされる
+	slow := stages[1]
+	assert.Equal(t, 100, len(slow.Outputs))
+	assert.Greater(t, slow.Count(OutputStatusSkipped), 80)
+	assert.Equal(t, 0, slow.FailureCount())
+}
@@ -41,6 +41,10 @@ func (m *testMapper) Map(ctx context.Context, input Record) ([]Record, error) {
 	if strings.Contains(gr, "error") {
 		return nil, errTestMapper
 	}
+	// Filtered
+	if strings.Contains(gr, "filtered") {
+		return nil, ErrFiltered
+	}
 	// Timeout
 	if strings.Contains(gr, "timeout") {
 		select {
@@ -107,3 +111,34 @@ var errTestBrokenGenerator = errors.New("test broken generator error")
 func (g *testBrokenGenerator) Map(ctx context.Context, input Record) ([]Record, error) {
 	return nil, errTestBrokenGenerator
 }
+
+type testBlockingMapper struct{}
+
+// contextが終了するまで処理をブロックする
+func (m *testBlockingMapper) Map(ctx context.Context, input Record) ([]Record, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+type testWaitMapper struct {
+	release chan struct{}
+}
+
+// releaseがcloseされるまで待ってから、入力のレコードをそのまま返す
+func (m *testWaitMapper) Map(ctx context.Context, input Record) ([]Record, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-m.release:
+	}
+	return []Record{input}, nil
+}
+
+type testSliceGenerator struct {
+	records []Record
+}
+
+// 指定されたレコードをそのまま生成する
+func (g *testSliceGenerator) Map(ctx context.Context, input Record) ([]Record, error) {
+	return g.records, nil
+}
@@ -0,0 +1,56 @@
+package pipeline
+
+import (
+	"context"
+
+	"golang.org/x/sync/semaphore"
+)
+
+// レコードの重みを返す関数
+type WeightFunc func(r Record) int64
+
+// 処理単位の重みの合計がcapacityを超えないように並列数を制限する
+// Mapperの場合はレコードの重み、Reducerの場合はグループに含まれるレコードの重みの合計を処理単位の重みとする
+// 重みは1からcapacityの範囲に丸められる
+func StageWeightedParallel(capacity int64, weight WeightFunc) PipelineStageOption {
+	return func(s *PipelineStage) {
+		s.weightCapacity = capacity
+		s.weight = weight
+	}
+}
+
+type weightedSemaphore struct {
+	capacity int64
+	weight   WeightFunc
+	sem      *semaphore.Weighted
+}
+
+func newWeightedSemaphore(capacity int64, weight WeightFunc) *weightedSemaphore {
+	return &weightedSemaphore{
+		capacity: capacity,
+		weight:   weight,
+		sem:      semaphore.NewWeighted(capacity),
+	}
+}
+
+// 処理単位の重みの分だけ実行枠を確保する
+// 実行枠を確保できた場合は、確保した重みを返す。処理単位の実行が終了したらreleaseで解放すること
+func (w *weightedSemaphore) acquire(ctx context.Context, records ...Record) (n int64, err error) {
+	for _, r := range records {
+		if _, ok := r.(groupCommit); ok {
+			continue
+		}
+		n += w.weight(Unwrap(r))
+	}
+	n = min(max(n, 1), w.capacity)
+
+	if err := w.sem.Acquire(ctx, n); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// acquireで確保した重みの分の実行枠を解放する
+func (w *weightedSemaphore) release(n int64) {
+	w.sem.Release(n)
+}
@@ -0,0 +1,107 @@
+package pipeline
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 実行中の処理単位の重みの合計の最大値を記録する
+type testWeightTracker struct {
+	mu      sync.Mutex
+	current int64
+	max     int64
+}
+
+func (tr *testWeightTracker) track(weight int64) func() {
+	tr.mu.Lock()
+	tr.current += weight
+	tr.max = max(tr.max, tr.current)
+	tr.mu.Unlock()
+
+	time.Sleep(20 * time.Millisecond)
+
+	return func() {
+		tr.mu.Lock()
+		tr.current -= weight
+		tr.mu.Unlock()
+	}
+}
+
+type testWeightedMapper struct {
+	tracker *testWeightTracker
+}
+
+func (m *testWeightedMapper) Map(ctx context.Context, input Record) ([]Record, error) {
+	defer m.tracker.track(min(testWeight(input), 3))()
+	return []Record{input}, nil
+}
+
+type testWeightedReducer struct {
+	tracker *testWeightTracker
+}
+
+func (r *testWeightedReducer) Reduce(ctx context.Context, group Group, inputs []Record) ([]Record, error) {
+	var weight int64
+	for _, in := range inputs {
+		weight += testWeight(in)
+	}
+	defer r.tracker.track(min(weight, 3))()
+	return nil, nil
+}
+
+// Identifierをそのまま重みとして扱う
+func testWeight(r Record) int64 {
+	w, _ := strconv.ParseInt(r.Identifier(), 10, 64)
+	return w
+}
+
+func TestStageWeightedParallel(t *testing.T) {
+	records := []Record{
+		testRecord{"group1", "2"},
+		testRecord{"group1", "1"},
+		testRecord{"group2", "1"},
+		testRecord{"group2", "1"},
+		testRecord{"group3", "5"}, // ASSERT: capacityを超える重みはcapacityに丸められる
+		testRecord{"group4", "1"},
+	}
+
+	tests := []struct {
+		name  string
+		stage func(tracker *testWeightTracker) *PipelineStage
+	}{
+		{
+			name: "map",
+			stage: func(tracker *testWeightTracker) *PipelineStage {
+				return MapStage("Map", &testWeightedMapper{tracker: tracker}, StageWeightedParallel(3, testWeight))
+			},
+		},
+		{
+			name: "reduce",
+			stage: func(tracker *testWeightTracker) *PipelineStage {
+				return ReduceStage("Reduce", &testWeightedReducer{tracker: tracker}, StageWeightedParallel(3, testWeight))
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := &testWeightTracker{}
+
+			_, stages, err := New(
+				MapStage("Generator", &testSliceGenerator{records: records}),
+				tt.stage(tracker),
+			).Execute(context.Background())
+			assert.NoError(t, err)
+
+			for _, o := range stages[1].Outputs {
+				assert.Equal(t, OutputStatusSuccess, o.Status)
+			}
+			// ASSERT: 実行中の重みの合計がcapacityを超えない
+			assert.Equal(t, int64(3), tracker.max)
+		})
+	}
+}
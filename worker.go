@@ -0,0 +1,77 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+)
+
+// 並列数の上限を設定しない場合の、ワーカーの数の上限
+const defaultMaxWorkers = 1024
+
+// 入力のレコードを受け取って処理する関数
+// errを返した場合は、以降の処理単位がスキップされるようにctxをキャンセルする
+type workerFunc func(ctx context.Context, in Record) error
+
+// ステージの入力をworkers個のワーカーで処理し、最初に発生したエラーを返す
+// ワーカーはゴルーチンを使い回して入力から直接レコードを取り出すため、実行枠が空いた時点で次のレコードが読み込まれる
+// workersが0以下の場合は、空いているワーカーがいない時だけdefaultMaxWorkers個を上限にワーカーを増やす
+// エラーが発生した後も、前段をブロックしないよう入力は最後まで読み込む
+func runWorkers(ctx context.Context, inputs <-chan Record, workers int, fn workerFunc) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	run := func(in Record) {
+		if err := fn(ctx, in); err != nil {
+			errOnce.Do(func() {
+				firstErr = err
+				cancel()
+			})
+		}
+	}
+
+	if workers <= 0 {
+		work := make(chan Record)
+		started := 0
+		for in := range inputs {
+			select {
+			case work <- in:
+				continue
+			default:
+			}
+
+			if started < defaultMaxWorkers {
+				started++
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					run(in)
+					for in := range work {
+						run(in)
+					}
+				}()
+				continue
+			}
+			work <- in
+		}
+		close(work)
+		wg.Wait()
+		return firstErr
+	}
+
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for in := range inputs {
+				run(in)
+			}
+		}()
+	}
+	wg.Wait()
+	return firstErr
+}
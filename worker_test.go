@@ -0,0 +1,193 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestRunWorkers(t *testing.T) {
+	errWorker := errors.New("worker error")
+
+	tests := []struct {
+		name         string
+		records      int
+		duration     time.Duration // 1件あたりの処理時間
+		workers      int
+		failAt       int
+		wantParallel int64
+		wantErr      error
+	}{
+		{
+			name:         "default",
+			workers:      0,
+			wantParallel: 10,
+		},
+		{
+			name:     "default limit",
+			records:  defaultMaxWorkers + 100,
+			duration: 500 * time.Millisecond,
+			workers:  0,
+			// ASSERT: 並列数の上限を設定しない場合も、ワーカーの数はdefaultMaxWorkersまでに制限される
+			wantParallel: defaultMaxWorkers,
+		},
+		{
+			name:         "workers",
+			workers:      3,
+			wantParallel: 3,
+		},
+		{
+			name:         "error",
+			workers:      2,
+			failAt:       1,
+			wantParallel: 2,
+			wantErr:      errWorker,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.records == 0 {
+				tt.records = 10
+			}
+			if tt.duration == 0 {
+				tt.duration = 20 * time.Millisecond
+			}
+
+			inputs := make(chan Record)
+			go func() {
+				defer close(inputs)
+				for i := range tt.records {
+					inputs <- testRecord{"group", fmt.Sprint(i)}
+				}
+			}()
+
+			var (
+				processed atomic.Int64
+				skipped   atomic.Int64
+				current   atomic.Int64
+				parallel  atomic.Int64
+			)
+			err := runWorkers(context.Background(), inputs, tt.workers, func(ctx context.Context, in Record) error {
+				// ASSERT: エラーが発生した後のレコードも読み込まれ、キャンセルされたctxで呼び出される
+				if ctx.Err() != nil {
+					skipped.Add(1)
+					return nil
+				}
+
+				n := current.Add(1)
+				defer current.Add(-1)
+				for {
+					p := parallel.Load()
+					if n <= p || parallel.CompareAndSwap(p, n) {
+						break
+					}
+				}
+
+				processed.Add(1)
+				time.Sleep(tt.duration)
+
+				if tt.failAt > 0 && in.Identifier() == fmt.Sprint(tt.failAt) {
+					return errWorker
+				}
+				return nil
+			})
+
+			assert.ErrorIs(t, err, tt.wantErr)
+			assert.Equal(t, int64(tt.records), processed.Load()+skipped.Load())
+			if tt.wantErr == nil {
+				assert.Equal(t, int64(0), skipped.Load())
+			} else {
+				assert.NotEqual(t, int64(0), skipped.Load())
+			}
+			assert.Equal(t, tt.wantParallel, parallel.Load())
+		})
+	}
+}
+
+type testIdentityMapper struct{}
+
+func (m *testIdentityMapper) Map(ctx context.Context, input Record) ([]Record, error) {
+	return []Record{input}, nil
+}
+
+// 比較のための、レコードごとにerrgroupでゴルーチンを起動する以前のMapperの実装
+type testErrgroupMapProcessor struct {
+	mapProcessor
+}
+
+func (p *testErrgroupMapProcessor) Process(ctx context.Context, inputs <-chan Record, abort chan<- error) <-chan Output {
+	outputs := make(chan Output)
+
+	eg, ctx := errgroup.WithContext(ctx)
+	if p.maxParallel > 0 {
+		eg.SetLimit(p.maxParallel)
+	}
+
+	go func() {
+		for in := range inputs {
+			if _, ok := in.(groupCommit); ok {
+				continue
+			}
+
+			eg.Go(func() error {
+				o, err := p.mapper.Map(ctx, in)
+				if err != nil {
+					outputs <- Output{Unit: RecordKey(in), Status: OutputStatusError, Err: err}
+					return nil
+				}
+				outputs <- Output{Unit: RecordKey(in), Status: OutputStatusSuccess, Records: o}
+				return nil
+			})
+		}
+
+		if err := eg.Wait(); err != nil {
+			abort <- err
+		}
+		close(outputs)
+	}()
+
+	return outputs
+}
+
+func BenchmarkMapStage(b *testing.B) {
+	benchmarks := []struct {
+		name     string
+		opts     []PipelineStageOption
+		previous bool
+	}{
+		{name: "previous", opts: []PipelineStageOption{}, previous: true},
+		{name: "previous with max parallel", opts: []PipelineStageOption{StageMaxParallel(8)}, previous: true},
+		{name: "default", opts: []PipelineStageOption{}},
+		{name: "workers", opts: []PipelineStageOption{StageMaxParallel(8)}},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			records := make([]Record, b.N)
+			for i := range records {
+				records[i] = testRecord{"group", fmt.Sprint(i)}
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			stage := MapStage("Map", &testIdentityMapper{}, bm.opts...)
+			if bm.previous {
+				stage = Stage(&testErrgroupMapProcessor{*newMapProcessor("Map", &testIdentityMapper{})}, bm.opts...)
+			}
+
+			_, _, err := New(
+				MapStage("Generator", &testSliceGenerator{records: records}),
+				stage,
+			).Execute(context.Background())
+			if err != nil {
+				b.Fatal(err)
+			}
+		})
+	}
+}